@@ -0,0 +1,410 @@
+// Copyright 2021-22 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet v5 monitoring counters and histograms
+
+package teomon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/kirill-scherba/bslice"
+)
+
+// DefaultBuckets is default histogram buckets upper bounds suitable for
+// latencies in milliseconds
+var DefaultBuckets = []float64{1, 2.5, 5, 10, 25, 50, 100, 250, 500, 1000,
+	2500, 5000, 10000}
+
+// Histogram parameters suffixes. Monitor keeps histogram aggregated values in
+// parameters with names: histogram name + suffix
+const (
+	SuffixCount = "_count"
+	SuffixSum   = "_sum"
+	SuffixP50   = "_p50"
+	SuffixP95   = "_p95"
+	SuffixP99   = "_p99"
+)
+
+// Aggregates fields tags
+const (
+	aggCounter byte = iota + 1
+	aggHistogram
+)
+
+// Counter is monotonic counter. Counter increments are sent to monitor as
+// deltas every aggregate interval and summed on monitor side
+type Counter struct {
+	delta uint64
+}
+
+// Inc increment counter by 1
+func (c *Counter) Inc() { c.Add(1) }
+
+// Add increment counter by n
+func (c *Counter) Add(n uint64) { atomic.AddUint64(&c.delta, n) }
+
+// Histogram is bucketed values distribution. Histogram observations are sent
+// to monitor as deltas every aggregate interval, monitor sums buckets and
+// estimates quantiles
+type Histogram struct {
+	bounds []float64
+	counts []uint64
+	sum    float64
+	count  uint64
+	sync.Mutex
+}
+
+// newHistogram create new histogram with sorted buckets upper bounds
+func newHistogram(bounds []float64) (h *Histogram) {
+	h = &Histogram{bounds: append([]float64{}, bounds...)}
+	sort.Float64s(h.bounds)
+	h.counts = make([]uint64, len(h.bounds)+1)
+	return
+}
+
+// Observe add value to histogram
+func (h *Histogram) Observe(v float64) {
+	h.Lock()
+	defer h.Unlock()
+	h.counts[sort.SearchFloat64s(h.bounds, v)]++
+	h.sum += v
+	h.count++
+}
+
+// reset return histogram values and reset it
+func (h *Histogram) reset() (counts []uint64, sum float64, count uint64) {
+	h.Lock()
+	defer h.Unlock()
+	counts, sum, count = h.counts, h.sum, h.count
+	h.counts = make([]uint64, len(h.bounds)+1)
+	h.sum, h.count = 0, 0
+	return
+}
+
+// add values to histogram
+func (h *Histogram) add(counts []uint64, sum float64, count uint64) {
+	h.Lock()
+	defer h.Unlock()
+	for i := range counts {
+		h.counts[i] += counts[i]
+	}
+	h.sum += sum
+	h.count += count
+}
+
+// aggregates contain monitor counters and histograms
+type aggregates struct {
+	counters   map[string]*Counter
+	histograms map[string]*Histogram
+	sync.Mutex
+}
+
+// Counter return counter with name, the counter is created on first call
+func (mon *Monitor) Counter(name string) (c *Counter) {
+	a := mon.aggregates()
+	a.Lock()
+	defer a.Unlock()
+	if c = a.counters[name]; c == nil {
+		c = new(Counter)
+		a.counters[name] = c
+	}
+	return
+}
+
+// Histogram return histogram with name, the histogram is created on first
+// call with buckets upper bounds or DefaultBuckets if bounds omitted
+func (mon *Monitor) Histogram(name string, bounds ...float64) (h *Histogram) {
+	a := mon.aggregates()
+	a.Lock()
+	defer a.Unlock()
+	if h = a.histograms[name]; h == nil {
+		if len(bounds) == 0 {
+			bounds = DefaultBuckets
+		}
+		h = newHistogram(bounds)
+		a.histograms[name] = h
+	}
+	return
+}
+
+// aggregates return monitor aggregates and start sending it to monitor every
+// aggregate interval on first call
+func (mon *Monitor) aggregates() *aggregates {
+	mon.aggOnce.Do(func() {
+		mon.mu.Lock()
+		mon.aggs = &aggregates{
+			counters:   make(map[string]*Counter),
+			histograms: make(map[string]*Histogram),
+		}
+		mon.mu.Unlock()
+		interval := mon.aggInterval
+		if interval <= 0 {
+			interval = DefaultAggregateInterval
+		}
+		mon.every(interval, func() { mon.sendAggregates() })
+	})
+	return mon.getAggregates()
+}
+
+// getAggregates return monitor aggregates or nil if it was not created yet
+func (mon *Monitor) getAggregates() *aggregates {
+	mon.mu.RLock()
+	defer mon.mu.RUnlock()
+	return mon.aggs
+}
+
+// sendAggregates send counters and histograms deltas to monitor. If send
+// failed the deltas are added back to counters and histograms and sent next
+// time
+func (mon *Monitor) sendAggregates() (err error) {
+	a := mon.getAggregates()
+	if a == nil {
+		return
+	}
+
+	type histogramDelta struct {
+		h      *Histogram
+		counts []uint64
+		sum    float64
+		count  uint64
+	}
+	counters := make(map[*Counter]uint64)
+	var histograms []histogramDelta
+
+	buf := new(bytes.Buffer)
+	writeHeader(buf)
+
+	a.Lock()
+	for name, c := range a.counters {
+		delta := atomic.SwapUint64(&c.delta, 0)
+		if delta == 0 {
+			continue
+		}
+		writeCounter(buf, name, delta)
+		counters[c] = delta
+	}
+	for name, h := range a.histograms {
+		counts, sum, count := h.reset()
+		if count == 0 {
+			continue
+		}
+		writeHistogram(buf, name, h.bounds, counts, sum)
+		histograms = append(histograms, histogramDelta{h, counts, sum, count})
+	}
+	a.Unlock()
+
+	if len(counters) == 0 && len(histograms) == 0 {
+		return
+	}
+	if err = mon.send(CmdAggregates, buf.Bytes()); err != nil {
+		for c, delta := range counters {
+			c.Add(delta)
+		}
+		for _, d := range histograms {
+			d.h.add(d.counts, d.sum, d.count)
+		}
+	}
+	return
+}
+
+// writeCounter write counter field
+func writeCounter(buf *bytes.Buffer, name string, value uint64) {
+	var b bslice.ByteSlice
+	field := new(bytes.Buffer)
+	b.WriteSlice(field, []byte(name))
+	binary.Write(field, binary.LittleEndian, value)
+	writeField(buf, aggCounter, field.Bytes())
+}
+
+// writeHistogram write histogram field
+func writeHistogram(buf *bytes.Buffer, name string, bounds []float64,
+	counts []uint64, sum float64) {
+
+	var b bslice.ByteSlice
+	field := new(bytes.Buffer)
+	b.WriteSlice(field, []byte(name))
+	binary.Write(field, binary.LittleEndian, uint16(len(bounds)))
+	binary.Write(field, binary.LittleEndian, bounds)
+	binary.Write(field, binary.LittleEndian, counts)
+	binary.Write(field, binary.LittleEndian, sum)
+	writeField(buf, aggHistogram, field.Bytes())
+}
+
+// peerAggregates contain counters totals and histograms summed on monitor
+// side for one peer
+type peerAggregates struct {
+	counters   map[string]uint64
+	histograms map[string]*Histogram
+	sync.Mutex
+}
+
+// newPeerAggregates create new peer aggregates
+func newPeerAggregates() *peerAggregates {
+	return &peerAggregates{
+		counters:   make(map[string]uint64),
+		histograms: make(map[string]*Histogram),
+	}
+}
+
+// MarshalBinary binary marshal peer aggregates totals in the aggregates
+// deltas format, so unmarshalled with process to empty peer aggregates
+func (a *peerAggregates) MarshalBinary() (data []byte, err error) {
+	buf := new(bytes.Buffer)
+	writeHeader(buf)
+
+	a.Lock()
+	defer a.Unlock()
+	for name, total := range a.counters {
+		writeCounter(buf, name, total)
+	}
+	for name, h := range a.histograms {
+		writeHistogram(buf, name, h.bounds, h.counts, h.sum)
+	}
+	return buf.Bytes(), nil
+}
+
+// process aggregates deltas received from monitoring client and return
+// updated parameters
+func (a *peerAggregates) process(data []byte) (params []Parameter, err error) {
+	var b bslice.ByteSlice
+
+	buf := bytes.NewBuffer(data)
+	if !readHeader(buf) {
+		err = fmt.Errorf("wrong aggregates header")
+		return
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	for buf.Len() > 0 {
+		var tag byte
+		var d []byte
+		if tag, d, err = readField(buf); err != nil {
+			return
+		}
+		field := bytes.NewBuffer(d)
+
+		switch tag {
+		case aggCounter:
+			var name string
+			var delta uint64
+			if name, err = b.ReadString(field); err != nil {
+				return
+			}
+			if err = binary.Read(field, binary.LittleEndian, &delta); err != nil {
+				return
+			}
+			a.counters[name] += delta
+			params = append(params, Parameter{Name: name, Value: a.counters[name]})
+
+		case aggHistogram:
+			var name string
+			var l uint16
+			var sum float64
+			if name, err = b.ReadString(field); err != nil {
+				return
+			}
+			if err = binary.Read(field, binary.LittleEndian, &l); err != nil {
+				return
+			}
+			bounds := make([]float64, l)
+			counts := make([]uint64, l+1)
+			if err = binary.Read(field, binary.LittleEndian, bounds); err != nil {
+				return
+			}
+			if err = binary.Read(field, binary.LittleEndian, counts); err != nil {
+				return
+			}
+			if err = binary.Read(field, binary.LittleEndian, &sum); err != nil {
+				return
+			}
+
+			// Reset histogram if buckets changed
+			h := a.histograms[name]
+			if h == nil || !equalBounds(h.bounds, bounds) {
+				h = newHistogram(bounds)
+				a.histograms[name] = h
+			}
+			for i := range counts {
+				h.counts[i] += counts[i]
+				h.count += counts[i]
+			}
+			h.sum += sum
+
+			params = append(params, h.params(name)...)
+		}
+	}
+	return
+}
+
+// params return histogram aggregated parameters
+func (h *Histogram) params(name string) []Parameter {
+	return []Parameter{
+		{Name: name + SuffixCount, Value: h.count},
+		{Name: name + SuffixSum, Value: h.sum},
+		{Name: name + SuffixP50, Value: h.quantile(0.50)},
+		{Name: name + SuffixP95, Value: h.quantile(0.95)},
+		{Name: name + SuffixP99, Value: h.quantile(0.99)},
+	}
+}
+
+// allParams return parameters of all peer aggregates
+func (a *peerAggregates) allParams() (params []Parameter) {
+	a.Lock()
+	defer a.Unlock()
+	for name, total := range a.counters {
+		params = append(params, Parameter{Name: name, Value: total})
+	}
+	for name, h := range a.histograms {
+		params = append(params, h.params(name)...)
+	}
+	return
+}
+
+// quantile estimate q quantile with linear interpolation inside bucket. Values
+// in the last +Inf bucket are estimated as the biggest bucket upper bound
+func (h *Histogram) quantile(q float64) float64 {
+	if h.count == 0 || len(h.bounds) == 0 {
+		return 0
+	}
+
+	rank := q * float64(h.count)
+	var cumulative uint64
+	for i, c := range h.counts {
+		if float64(cumulative+c) < rank || c == 0 {
+			cumulative += c
+			continue
+		}
+		if i == len(h.bounds) {
+			break
+		}
+		lower := 0.0
+		if i > 0 {
+			lower = h.bounds[i-1]
+		}
+		upper := h.bounds[i]
+		return lower + (upper-lower)*(rank-float64(cumulative))/float64(c)
+	}
+	return h.bounds[len(h.bounds)-1]
+}
+
+// equalBounds return true if buckets bounds are equal
+func equalBounds(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,151 @@
+package teomon
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAggregates(t *testing.T) {
+
+	peers := NewPeers()
+	teo := &fakeTeonet{address: "client-1", peers: peers}
+	mon, _ := ConnectContext(context.Background(), teo, "monitor", *NewMetric(),
+		WithAggregateInterval(time.Hour))
+
+	requests := mon.Counter("requests")
+	rpc := mon.Histogram("rpc_ms", 10, 20, 30, 40)
+	for i := 0; i < 100; i++ {
+		requests.Inc()
+		rpc.Observe(float64(i%40) + 0.5)
+	}
+	mon.sendAggregates()
+
+	// Deltas summed on monitor
+	mon.Counter("requests").Add(5)
+	mon.sendAggregates()
+
+	metric, _ := peers.Get("client-1")
+	if val, _ := metric.Params.Get("requests"); val != uint64(105) {
+		t.Error("wrong counter total", val)
+		return
+	}
+	if val, _ := metric.Params.Get("rpc_ms" + SuffixCount); val != uint64(100) {
+		t.Error("wrong histogram count", val)
+		return
+	}
+	p50, _ := metric.Params.Get("rpc_ms" + SuffixP50)
+	p99, _ := metric.Params.Get("rpc_ms" + SuffixP99)
+	if p50.(float64) < 15 || p50.(float64) > 25 || p99.(float64) < 35 ||
+		p99.(float64) > 40 {
+		t.Error("wrong histogram quantiles", p50, p99)
+		return
+	}
+
+	// Aggregates kept after client reconnect
+	teo.ConnectTo("monitor")
+	metric, _ = peers.Get("client-1")
+	if val, _ := metric.Params.Get("requests"); val != uint64(105) {
+		t.Error("counter total lost after reconnect", val)
+		return
+	}
+
+	// Aggregates sent on close and shown in json
+	requests.Inc()
+	mon.Close()
+	data, err := peers.Json()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var out []struct{ Params map[string]interface{} }
+	if err = json.Unmarshal(data, &out); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(out) != 1 || out[0].Params["requests"] != 106.0 {
+		t.Error("wrong json:", string(data))
+		return
+	}
+	if !strings.Contains(peers.String(), "rpc_ms_p95") {
+		t.Error("histogram not shown in table")
+		return
+	}
+}
+
+func TestAggregatesRestore(t *testing.T) {
+
+	file := filepath.Join(t.TempDir(), "peers.dat")
+
+	peers := NewPeers()
+	teo := &fakeTeonet{address: "client-1", peers: peers}
+	mon, _ := ConnectContext(context.Background(), teo, "monitor", *NewMetric(),
+		WithAggregateInterval(time.Hour))
+	mon.Counter("requests").Add(100)
+	mon.Histogram("rpc_ms", 10, 20).Observe(5)
+	mon.sendAggregates()
+	if err := peers.Save(file); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Monitor restarted: totals continue from saved values
+	restored := NewPeers()
+	if err := restored.Load(file); err != nil {
+		t.Error(err)
+		return
+	}
+	teo.Lock()
+	teo.peers = restored
+	teo.Unlock()
+	teo.ConnectTo("monitor")
+	mon.Counter("requests").Inc()
+	mon.Histogram("rpc_ms").Observe(15)
+	mon.sendAggregates()
+
+	metric, _ := restored.Get("client-1")
+	if val, _ := metric.Params.Get("requests"); val != uint64(101) {
+		t.Error("wrong restored counter total", val)
+		return
+	}
+	if val, _ := metric.Params.Get("rpc_ms" + SuffixCount); val != uint64(2) {
+		t.Error("wrong restored histogram count", val)
+		return
+	}
+}
+
+func TestAggregatesSendFailed(t *testing.T) {
+
+	peers := NewPeers()
+	teo := &fakeTeonet{address: "client-1", peers: peers}
+	mon, _ := ConnectContext(context.Background(), teo, "monitor", *NewMetric(),
+		WithAggregateInterval(time.Hour))
+
+	// Send failed: deltas are kept
+	peers.Del("client-1")
+	mon.Counter("requests").Add(10)
+	mon.Histogram("rpc_ms", 10).Observe(5)
+	if err := mon.sendAggregates(); err == nil {
+		t.Error("send not failed")
+		return
+	}
+
+	teo.ConnectTo("monitor")
+	mon.Counter("requests").Inc()
+	if err := mon.sendAggregates(); err != nil {
+		t.Error(err)
+		return
+	}
+	metric, _ := peers.Get("client-1")
+	if val, _ := metric.Params.Get("requests"); val != uint64(11) {
+		t.Error("wrong counter total after failed send", val)
+		return
+	}
+	if val, _ := metric.Params.Get("rpc_ms" + SuffixCount); val != uint64(1) {
+		t.Error("wrong histogram count after failed send", val)
+		return
+	}
+}
@@ -0,0 +1,358 @@
+// Copyright 2021-22 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet v5 monitoring alerting rules
+
+package teomon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AlertState is alert state
+type AlertState byte
+
+// Alert states
+const (
+	AlertFiring AlertState = iota + 1
+	AlertResolved
+)
+
+// String return alert state name
+func (s AlertState) String() string {
+	switch s {
+	case AlertFiring:
+		return "firing"
+	case AlertResolved:
+		return "resolved"
+	}
+	return fmt.Sprintf("state_%d", byte(s))
+}
+
+// MarshalJSON json marshal alert state as its name
+func (s AlertState) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// Rule is alerting rule. The Expr is parameter condition in format
+// 'name op value', where op is one of ==, !=, <, <=, >, >= and value is bool,
+// number or string, for example: 'online == false', 'peers < 1',
+// 'heap_alloc > 500'. The expression may end with 'for duration', for
+// example 'online == false for 2m', which sets For. Alert fires when
+// condition is true for For duration
+type Rule struct {
+	Name string
+	Expr string
+	For  time.Duration
+
+	param string
+	op    string
+	value interface{}
+}
+
+// Alert is alert notification
+type Alert struct {
+	Rule    string      `json:"rule"`
+	Expr    string      `json:"expr"`
+	State   AlertState  `json:"state"`
+	Address string      `json:"address"`
+	Param   string      `json:"param"`  // Parameter name
+	Value   interface{} `json:"value"`  // Parameter value
+	Since   time.Time   `json:"since"`  // Condition became true
+	Time    time.Time   `json:"time"`   // Alert state changed
+	Metric  Pmetric     `json:"metric"` // Peer metric snapshot
+}
+
+// Notifier send alert notifications
+type Notifier interface {
+	Notify(ctx context.Context, a Alert) error
+}
+
+// NotifierFunc is function which implements Notifier
+type NotifierFunc func(ctx context.Context, a Alert) error
+
+// Notify call f(ctx, a)
+func (f NotifierFunc) Notify(ctx context.Context, a Alert) error { return f(ctx, a) }
+
+// ParseRule parse rule expression and return rule
+func ParseRule(name, expr string) (r Rule, err error) {
+	r = Rule{Name: name, Expr: expr}
+	err = r.parse()
+	return
+}
+
+// parse rule expression
+func (r *Rule) parse() (err error) {
+	expr := strings.TrimSpace(r.Expr)
+
+	// Duration
+	if i := strings.LastIndex(expr, " for "); i >= 0 {
+		if r.For, err = time.ParseDuration(strings.TrimSpace(expr[i+5:])); err != nil {
+			return fmt.Errorf("rule %s: wrong duration: %w", r.Name, err)
+		}
+		expr = strings.TrimSpace(expr[:i])
+	}
+
+	// Condition
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		i := strings.Index(expr, op)
+		if i < 0 {
+			continue
+		}
+		r.param = strings.TrimSpace(expr[:i])
+		r.op = op
+		value := strings.TrimSpace(expr[i+len(op):])
+		if r.param == "" || value == "" {
+			break
+		}
+		r.value = parseRuleValue(value)
+		return
+	}
+	return fmt.Errorf("rule %s: wrong expression: %s", r.Name, r.Expr)
+}
+
+// parseRuleValue parse rule value: bool, number or string
+func parseRuleValue(s string) interface{} {
+	switch s {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f
+	}
+	if u, err := strconv.Unquote(s); err == nil {
+		return u
+	}
+	return s
+}
+
+// match return true if parameters value match rule condition
+func (r *Rule) match(v interface{}) bool {
+	var cmp int
+	switch rv := r.value.(type) {
+	case bool:
+		b, ok := v.(bool)
+		if !ok {
+			return false
+		}
+		if b != rv {
+			cmp = 1
+		}
+		if r.op != "==" && r.op != "!=" {
+			return false
+		}
+	case float64:
+		f, ok := numericValue(v)
+		if !ok {
+			return false
+		}
+		switch {
+		case f < rv:
+			cmp = -1
+		case f > rv:
+			cmp = 1
+		}
+	case string:
+		s, ok := v.(string)
+		if !ok {
+			return false
+		}
+		cmp = strings.Compare(s, rv)
+	}
+
+	switch r.op {
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+// alertKey is rule and peer alert state key
+type alertKey struct {
+	rule    string
+	address string
+}
+
+// alertState is rule and peer alert state
+type alertState struct {
+	since  time.Time
+	firing bool
+	value  interface{}
+}
+
+// Alerter evaluate alerting rules over peers parameters and send firing and
+// resolved alerts to notifiers. Alerts of offline peers which have parameter
+// mayoffline set to true are suppressed
+type Alerter struct {
+	OnError func(err error) // Notifiers errors handler
+
+	peers     *Peers
+	rules     []Rule
+	notifiers []Notifier
+	states    map[alertKey]*alertState
+	sync.Mutex
+}
+
+// NewAlerter create new alerter which evaluate rules over peers parameters.
+// Rules names are alerts identifiers, so they must be unique and not empty
+func NewAlerter(peers *Peers, rules ...Rule) (a *Alerter, err error) {
+	a = &Alerter{peers: peers, states: make(map[alertKey]*alertState)}
+	names := make(map[string]bool)
+	for _, r := range rules {
+		switch {
+		case r.Name == "":
+			return nil, fmt.Errorf("rule %s: empty name", r.Expr)
+		case names[r.Name]:
+			return nil, fmt.Errorf("rule %s: duplicate name", r.Name)
+		}
+		names[r.Name] = true
+		if err = r.parse(); err != nil {
+			return nil, err
+		}
+		a.rules = append(a.rules, r)
+	}
+	return
+}
+
+// AddNotifier add alerts notifier
+func (a *Alerter) AddNotifier(n Notifier) {
+	a.Lock()
+	defer a.Unlock()
+	a.notifiers = append(a.notifiers, n)
+}
+
+// Run evaluate rules every interval until context done
+func (a *Alerter) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case now := <-ticker.C:
+			a.Evaluate(ctx, now)
+		}
+	}
+}
+
+// Evaluate rules for all peers at time now and send alerts which state
+// changed to notifiers
+func (a *Alerter) Evaluate(ctx context.Context, now time.Time) {
+	var alerts []Alert
+
+	a.Lock()
+	seen := make(map[alertKey]bool)
+	a.peers.Each(func(m *Metric) {
+		mayOffline, _ := m.Params.Get(MayOffline)
+		online, _ := m.Params.Get(ParamOnline)
+		suppressed := mayOffline == true && online == false
+
+		for i := range a.rules {
+			r := &a.rules[i]
+			key := alertKey{r.Name, m.Address}
+			seen[key] = true
+
+			value, ok := m.Params.Get(r.param)
+			matched := ok && !suppressed && r.match(value)
+			if alert, ok := a.update(key, r, matched, value, now); ok {
+				alert.Metric = newPmetric(m)
+				alerts = append(alerts, alert)
+			}
+		}
+	})
+
+	// Resolve alerts of removed peers
+	for key, state := range a.states {
+		if seen[key] {
+			continue
+		}
+		if state.firing {
+			alerts = append(alerts, Alert{Rule: key.rule, State: AlertResolved,
+				Address: key.address, Value: state.value, Since: state.since,
+				Time: now, Metric: Pmetric{Metric: Metric{Address: key.address}}})
+		}
+		delete(a.states, key)
+	}
+	notifiers := a.notifiers
+	a.Unlock()
+
+	sort.SliceStable(alerts, func(i, j int) bool {
+		return alerts[i].Address < alerts[j].Address
+	})
+	for _, alert := range alerts {
+		for _, n := range notifiers {
+			if err := n.Notify(ctx, alert); err != nil && a.OnError != nil {
+				a.OnError(fmt.Errorf("notify alert %s %s: %w", alert.Rule,
+					alert.Address, err))
+			}
+		}
+	}
+}
+
+// update rule and peer alert state and return alert if state changed
+func (a *Alerter) update(key alertKey, r *Rule, matched bool,
+	value interface{}, now time.Time) (alert Alert, ok bool) {
+
+	state := a.states[key]
+	if state == nil {
+		state = new(alertState)
+		a.states[key] = state
+	}
+	state.value = value
+
+	alert = Alert{Rule: r.Name, Expr: r.Expr, Address: key.address,
+		Param: r.param, Value: value, Time: now}
+
+	switch {
+	case matched && state.since.IsZero():
+		state.since = now
+		fallthrough
+	case matched && !state.firing:
+		if now.Sub(state.since) < r.For {
+			return
+		}
+		state.firing = true
+		alert.State, alert.Since, ok = AlertFiring, state.since, true
+	case !matched && state.firing:
+		alert.State, alert.Since, ok = AlertResolved, state.since, true
+		state.firing = false
+		state.since = time.Time{}
+	case !matched:
+		state.since = time.Time{}
+	}
+	return
+}
+
+// Alerts return currently firing alerts
+func (a *Alerter) Alerts() (alerts []Alert) {
+	a.Lock()
+	defer a.Unlock()
+	for key, state := range a.states {
+		if !state.firing {
+			continue
+		}
+		alerts = append(alerts, Alert{Rule: key.rule, State: AlertFiring,
+			Address: key.address, Value: state.value, Since: state.since})
+	}
+	return
+}
@@ -0,0 +1,94 @@
+package teomon
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAlerter(t *testing.T) {
+
+	peers := NewPeers()
+	m1 := NewMetric()
+	m1.Address = "a1"
+	peers.Add(m1)
+	m1.Params.Add(ParamPeers, 3)
+	m2 := NewMetric()
+	m2.Address = "a2"
+	peers.Add(m2)
+	m2.Params.Add(MayOffline, true)
+
+	offline, _ := ParseRule("offline", "online == false for 2m")
+	alerter, err := NewAlerter(peers, offline, Rule{Name: "no-peers", Expr: "peers < 1"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var alerts []Alert
+	alerter.AddNotifier(NotifierFunc(func(ctx context.Context, a Alert) error {
+		alerts = append(alerts, a)
+		return nil
+	}))
+
+	ctx := context.Background()
+	now := time.Now()
+
+	// Peers dropped to zero fires immediately
+	m1.Params.Add(ParamPeers, 0)
+	alerter.Evaluate(ctx, now)
+	if len(alerts) != 1 || alerts[0].Rule != "no-peers" ||
+		alerts[0].State != AlertFiring || alerts[0].Address != "a1" {
+		t.Error("wrong no-peers alert", alerts)
+		return
+	}
+
+	// Offline fires after 2 minutes, mayoffline peer suppressed
+	alerts = nil
+	peers.Disconnected("a1")
+	peers.Disconnected("a2")
+	alerter.Evaluate(ctx, now.Add(time.Minute))
+	if len(alerts) != 0 {
+		t.Error("offline alert fired before for duration", alerts)
+		return
+	}
+	alerter.Evaluate(ctx, now.Add(3*time.Minute+time.Second))
+	if len(alerts) != 1 || alerts[0].Rule != "offline" ||
+		alerts[0].Address != "a1" {
+		t.Error("wrong offline alert", alerts)
+		return
+	}
+	if len(alerter.Alerts()) != 2 {
+		t.Error("wrong number of firing alerts", alerter.Alerts())
+		return
+	}
+
+	// Resolved
+	alerts = nil
+	m1.Params.Add(ParamOnline, true)
+	m1.Params.Add(ParamPeers, 2)
+	alerter.Evaluate(ctx, now.Add(4*time.Minute))
+	if len(alerts) != 2 || alerts[0].State != AlertResolved ||
+		alerts[1].State != AlertResolved {
+		t.Error("alerts not resolved", alerts)
+		return
+	}
+
+	// Wrong expression
+	if _, err = ParseRule("wrong", "online"); err == nil {
+		t.Error("wrong expression parsed")
+		return
+	}
+}
+
+func TestAlerterRuleNames(t *testing.T) {
+
+	for _, rules := range [][]Rule{
+		{{Name: "", Expr: "peers < 1"}},
+		{{Name: "r", Expr: "peers < 1"}, {Name: "r", Expr: "online == false"}},
+	} {
+		if _, err := NewAlerter(NewPeers(), rules...); err == nil {
+			t.Error("wrong rules names accepted", rules)
+			return
+		}
+	}
+}
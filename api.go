@@ -0,0 +1,151 @@
+// Copyright 2021-22 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet v5 monitoring HTTP JSON API
+
+package teomon
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// APIHandler return http handler of peers JSON API:
+//
+//	GET    /peers                   - list of peers
+//	GET    /peers/{address}         - peer
+//	GET    /peers/{address}/params  - peer parameters
+//	DELETE /peers/{address}         - delete peer
+//
+// The list of peers may be filtered with query parameters: app - application
+// short or full name, online - true or false, version - application version
+func (p *Peers) APIHandler() http.Handler {
+	return http.HandlerFunc(p.serveAPI)
+}
+
+// serveAPI serve peers JSON API requests
+func (p *Peers) serveAPI(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+	parts := strings.Split(path, "/")
+	if parts[0] != "peers" || len(parts) > 3 {
+		http.NotFound(w, r)
+		return
+	}
+
+	// List of peers
+	if len(parts) == 1 {
+		if r.Method != http.MethodGet {
+			apiMethodNotAllowed(w, http.MethodGet)
+			return
+		}
+		p.apiList(w, r)
+		return
+	}
+
+	address, err := url.PathUnescape(parts[1])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Peer parameters
+	if len(parts) == 3 {
+		if parts[2] != "params" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodGet {
+			apiMethodNotAllowed(w, http.MethodGet)
+			return
+		}
+		m, ok := p.Get(address)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		apiWriteJSON(w, m.Params)
+		return
+	}
+
+	// Peer
+	switch r.Method {
+	case http.MethodGet:
+		m, ok := p.Get(address)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		apiWriteJSON(w, newPmetric(m))
+	case http.MethodDelete:
+		if _, ok := p.Del(address); !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		apiMethodNotAllowed(w, http.MethodGet, http.MethodDelete)
+	}
+}
+
+// apiList write filtered list of peers
+func (p *Peers) apiList(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	app := query.Get("app")
+	ver := query.Get("version")
+	var online *bool
+	if s := query.Get("online"); s != "" {
+		val, err := strconv.ParseBool(s)
+		if err != nil {
+			http.Error(w, "wrong online value: "+s, http.StatusBadRequest)
+			return
+		}
+		online = &val
+	}
+
+	// Sort copy of metrics, the handler may run concurrently
+	p.RLock()
+	ms := append([]*Metric(nil), p.metrics...)
+	p.RUnlock()
+	p.sortMetrics(ms)
+
+	pmetrics := []Pmetric{}
+	for _, m := range ms {
+		if app != "" && m.AppShort != app && m.AppName != app {
+			continue
+		}
+		if ver != "" && m.AppVersion != ver {
+			continue
+		}
+		if online != nil {
+			val, _ := m.Params.Get(ParamOnline)
+			if val != *online {
+				continue
+			}
+		}
+		pmetrics = append(pmetrics, newPmetric(m))
+	}
+
+	apiWriteJSON(w, pmetrics)
+}
+
+// apiWriteJSON write value in json format
+func apiWriteJSON(w http.ResponseWriter, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// apiMethodNotAllowed write method not allowed error
+func apiMethodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	http.Error(w, http.StatusText(http.StatusMethodNotAllowed),
+		http.StatusMethodNotAllowed)
+}
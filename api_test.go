@@ -0,0 +1,125 @@
+package teomon
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestAPIHandler(t *testing.T) {
+
+	peers := NewPeers()
+	for _, a := range []struct {
+		address, app, ver string
+		online            bool
+	}{
+		{"a1", "app-1", "0.0.1", true},
+		{"a2", "app-1", "0.0.2", false},
+		{"a3", "app-2", "0.0.1", true},
+	} {
+		m := NewMetric()
+		m.Address, m.AppShort, m.AppVersion = a.address, a.app, a.ver
+		peers.Add(m)
+		m.Params.Add(ParamOnline, a.online)
+		m.Params.Add("num_users", 10)
+	}
+
+	srv := httptest.NewServer(peers.APIHandler())
+	defer srv.Close()
+
+	// request send request and decode json response
+	request := func(method, path string, v interface{}) int {
+		req, _ := http.NewRequest(method, srv.URL+path, nil)
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if v != nil {
+			json.NewDecoder(resp.Body).Decode(v)
+		}
+		return resp.StatusCode
+	}
+
+	var list []Pmetric
+	for query, expected := range map[string]int{
+		"":                            3,
+		"?app=app-1":                  2,
+		"?app=app-1&online=true":      1,
+		"?version=0.0.1":              2,
+		"?online=false&version=0.0.1": 0,
+	} {
+		list = nil
+		if code := request("GET", "/peers"+query, &list); code != 200 ||
+			len(list) != expected {
+			t.Error("wrong peers list", query, code, len(list))
+			return
+		}
+	}
+
+	var pm Pmetric
+	if code := request("GET", "/peers/a2", &pm); code != 200 ||
+		pm.Address != "a2" || pm.Online != false {
+		t.Error("wrong peer", code, pm.Address, pm.Online)
+		return
+	}
+
+	var params map[string]interface{}
+	if code := request("GET", "/peers/a2/params", &params); code != 200 ||
+		params["num_users"] != 10.0 {
+		t.Error("wrong peer params", code, params)
+		return
+	}
+
+	if code := request("DELETE", "/peers/a2", nil); code != 204 {
+		t.Error("wrong delete status", code)
+		return
+	}
+	if code := request("GET", "/peers/a2", nil); code != 404 {
+		t.Error("deleted peer found", code)
+		return
+	}
+	if code := request("POST", "/peers", nil); code != 405 {
+		t.Error("wrong method status", code)
+		return
+	}
+}
+
+func TestAPIHandlerConcurrent(t *testing.T) {
+
+	peers := NewPeers()
+	for i, app := range []string{"app-3", "app-1", "app-2", "app-1"} {
+		m := NewMetric()
+		m.Address, m.AppShort = string(rune('a'+i)), app
+		peers.Add(m)
+		m.Params.Add(ParamOnline, i%2 == 0)
+	}
+
+	srv := httptest.NewServer(peers.APIHandler())
+	defer srv.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				resp, err := srv.Client().Get(srv.URL + "/peers")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				var list []Pmetric
+				json.NewDecoder(resp.Body).Decode(&list)
+				resp.Body.Close()
+				if len(list) != 4 {
+					t.Error("wrong peers list", len(list))
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,133 @@
+// Copyright 2021-22 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet v5 monitoring parameters batches
+
+package teomon
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+)
+
+// SendParams send parameters to monitor in one packet. Parameters which can't
+// be marshalled are skipped, first marshal or teonet send error is returned
+// and passed to error handler set by OnError
+func (mon *Monitor) SendParams(params map[string]interface{}) (err error) {
+	if mon.isClosed() {
+		return ErrMonitorClosed
+	}
+	return mon.sendParams(params)
+}
+
+// sendParams send parameters to monitor in one packet
+func (mon *Monitor) sendParams(params map[string]interface{}) (err error) {
+	if len(params) == 0 {
+		return
+	}
+
+	var n int
+	buf := new(bytes.Buffer)
+	writeHeader(buf)
+	for name, value := range params {
+		data, e := Parameter{Name: name, Value: value}.MarshalBinary()
+		if e != nil {
+			e = fmt.Errorf("marshal parameter %s: %w", name, e)
+			mon.error(e)
+			if err == nil {
+				err = e
+			}
+			continue
+		}
+		writeField(buf, batchParam, data)
+		n++
+	}
+	if n == 0 {
+		return
+	}
+
+	if e := mon.send(CmdParameters, buf.Bytes()); e != nil && err == nil {
+		err = e
+	}
+	return
+}
+
+// paramsMap return parameters slice as map
+func paramsMap(params []Parameter) (m map[string]interface{}) {
+	m = make(map[string]interface{}, len(params))
+	for _, p := range params {
+		m[p.Name] = p.Value
+	}
+	return
+}
+
+// queue parameter to send it after flush window
+func (mon *Monitor) queue(name string, value interface{}) (err error) {
+
+	// Check value can be marshalled
+	if _, _, err = encodeValue(value); err != nil {
+		err = fmt.Errorf("marshal parameter %s: %w", name, err)
+		mon.error(err)
+		return
+	}
+
+	// Closed state is checked under pending lock which Close holds while it
+	// set monitor closed, so nothing is queued after Close flush
+	mon.pendingMu.Lock()
+	defer mon.pendingMu.Unlock()
+	if mon.isClosed() {
+		return ErrMonitorClosed
+	}
+
+	if mon.pending == nil {
+		mon.pending = make(map[string]interface{})
+	}
+	mon.pending[name] = value
+	if mon.flushTimer == nil {
+		mon.flushTimer = time.AfterFunc(mon.flushWindow, mon.flush)
+	}
+	return
+}
+
+// flush send queued parameters to monitor
+func (mon *Monitor) flush() {
+	mon.pendingMu.Lock()
+	params := mon.pending
+	mon.pending = nil
+	if mon.flushTimer != nil {
+		mon.flushTimer.Stop()
+		mon.flushTimer = nil
+	}
+	mon.pendingMu.Unlock()
+
+	mon.sendParams(params)
+}
+
+// UnmarshalParams binary unmarshal parameters batch sent with CmdParameters
+// command
+func UnmarshalParams(data []byte) (params []Parameter, err error) {
+	buf := bytes.NewBuffer(data)
+	if !readHeader(buf) {
+		err = fmt.Errorf("wrong parameters batch header")
+		return
+	}
+
+	for buf.Len() > 0 {
+		var tag byte
+		var d []byte
+		if tag, d, err = readField(buf); err != nil {
+			return
+		}
+		if tag != batchParam {
+			continue
+		}
+		var p Parameter
+		if p.UnmarshalBinary(d) != nil {
+			continue
+		}
+		params = append(params, p)
+	}
+	return
+}
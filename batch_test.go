@@ -0,0 +1,96 @@
+package teomon
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSendParams(t *testing.T) {
+
+	peers := NewPeers()
+	teo := &fakeTeonet{address: "client-1", peers: peers, numPeers: 2}
+	mon, err := ConnectContext(context.Background(), teo, "monitor", *NewMetric(),
+		WithFlushWindow(10*time.Millisecond))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// On connect parameters sent in one batch
+	if teo.sent[CmdParameters] != 1 || teo.sent[CmdParameter] != 0 {
+		t.Error("wrong on connect packets", teo.sent)
+		return
+	}
+
+	// Coalesce parameters sent during flush window
+	mon.SendParam("a", 1)
+	mon.SendParam("b", "b")
+	mon.SendParam("a", 2)
+	time.Sleep(30 * time.Millisecond)
+
+	teo.Lock()
+	sent := teo.sent[CmdParameters]
+	teo.Unlock()
+	if sent != 2 {
+		t.Error("wrong number of batches", sent)
+		return
+	}
+
+	metric, _ := peers.Get("client-1")
+	a, _ := metric.Params.Get("a")
+	b, _ := metric.Params.Get("b")
+	if a != 2 || b != "b" {
+		t.Error("wrong batch parameters", a, b)
+		return
+	}
+
+	// Queued parameters flushed on close
+	mon.SendParam("c", true)
+	mon.Close()
+	if c, _ := metric.Params.Get("c"); c != true {
+		t.Error("queued parameter not flushed on close")
+		return
+	}
+}
+
+func TestSendParamCloseRace(t *testing.T) {
+
+	peers := NewPeers()
+	teo := &fakeTeonet{address: "client-1", peers: peers}
+	mon, _ := ConnectContext(context.Background(), teo, "monitor", *NewMetric(),
+		WithFlushWindow(time.Millisecond))
+
+	var mu sync.Mutex
+	var names []string
+	peers.Subscribe(func(e Event) {
+		if e.Type == ParamChanged {
+			mu.Lock()
+			names = append(names, e.Name)
+			mu.Unlock()
+		}
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 1; mon.SendParam("x", j) == nil; j++ {
+			}
+		}()
+	}
+	time.Sleep(5 * time.Millisecond)
+	mon.Close()
+	wg.Wait()
+	time.Sleep(10 * time.Millisecond)
+
+	// Nothing is sent after goingoffline
+	mu.Lock()
+	defer mu.Unlock()
+	if l := len(names); l < 2 || names[l-2] != ParamGoingOffline {
+		t.Error("parameters sent after goingoffline", l)
+		return
+	}
+}
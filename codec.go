@@ -0,0 +1,42 @@
+// Copyright 2021-22 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet v5 monitoring custom parameter value codecs
+
+package teomon
+
+import "sync"
+
+// valueCodec is custom parameter value encoder and decoder
+type valueCodec struct {
+	enc func(interface{}) ([]byte, error)
+	dec func([]byte) (interface{}, error)
+}
+
+// valueCodecs is registry of custom parameter value codecs
+var valueCodecs = struct {
+	m map[string]valueCodec
+	sync.RWMutex
+}{m: make(map[string]valueCodec)}
+
+// RegisterValueCodec register custom parameter value codec. The typeName is
+// name of value type returned by reflect.TypeOf(value).String(), for example
+// "main.Histogram". The typeName is sent to monitor with encoded value, so the
+// same codec should be registered on both client and monitor side. Registered
+// codec overrides builtin encoding of the type
+func RegisterValueCodec(typeName string, enc func(interface{}) ([]byte, error),
+	dec func([]byte) (interface{}, error)) {
+
+	valueCodecs.Lock()
+	defer valueCodecs.Unlock()
+	valueCodecs.m[typeName] = valueCodec{enc, dec}
+}
+
+// getValueCodec return custom parameter value codec by type name
+func getValueCodec(typeName string) (c valueCodec, ok bool) {
+	valueCodecs.RLock()
+	defer valueCodecs.RUnlock()
+	c, ok = valueCodecs.m[typeName]
+	return
+}
@@ -0,0 +1,47 @@
+package teomon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// latency is custom parameter value type used in tests
+type latency struct {
+	Buckets [3]uint32
+}
+
+func TestRegisterValueCodec(t *testing.T) {
+
+	RegisterValueCodec("teomon.latency",
+		func(v interface{}) ([]byte, error) {
+			buf := new(bytes.Buffer)
+			err := binary.Write(buf, binary.LittleEndian, v.(latency))
+			return buf.Bytes(), err
+		},
+		func(data []byte) (interface{}, error) {
+			var l latency
+			err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &l)
+			return l, err
+		},
+	)
+
+	val := latency{Buckets: [3]uint32{1, 20, 300}}
+	par := Parameter{Name: "rpc_latency", Value: val}
+	data, err := par.MarshalBinary()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	par = Parameter{}
+	if err = par.UnmarshalBinary(data); err != nil {
+		t.Error(err)
+		return
+	}
+	if !reflect.DeepEqual(par.Value, val) {
+		t.Error("wrong unmarshal custom value", par.Value)
+		return
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2021-22 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet v5 monitoring client connect options
+
+package teomon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// ErrMaxAttempts returned by ConnectContext when maximum number of connect
+// attempts reached. The returned error wraps it and contains the last connect
+// error
+var ErrMaxAttempts = errors.New("maximum number of connect attempts reached")
+
+// Default connect backoff values
+const (
+	DefaultMinDelay   = 1 * time.Second
+	DefaultMaxDelay   = 30 * time.Second
+	DefaultMultiplier = 2.0
+	DefaultJitter     = 0.2
+
+	DefaultAggregateInterval = 5 * time.Second
+)
+
+// ConnectOption is ConnectContext option
+type ConnectOption func(o *connectOptions)
+
+// connectOptions contain ConnectContext options
+type connectOptions struct {
+	teocheck    TeonetInterface
+	minDelay    time.Duration
+	maxDelay    time.Duration
+	multiplier  float64
+	jitter      float64
+	maxAttempts int
+	onFail      func(attempt int, err error)
+	flushWindow time.Duration
+	aggInterval time.Duration
+}
+
+// newConnectOptions create connect options with default values and apply
+// opts to it
+func newConnectOptions(teo TeonetInterface, opts ...ConnectOption) (o *connectOptions) {
+	o = &connectOptions{
+		teocheck:   teo,
+		minDelay:   DefaultMinDelay,
+		maxDelay:   DefaultMaxDelay,
+		multiplier: DefaultMultiplier,
+		jitter:     DefaultJitter,
+
+		aggInterval: DefaultAggregateInterval,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return
+}
+
+// WithTeonetCheck set teonet used to check number of connected peers. By
+// default the teonet used to connect to monitor is used
+func WithTeonetCheck(t TeonetInterface) ConnectOption {
+	return func(o *connectOptions) { o.teocheck = t }
+}
+
+// WithBackoff set delay between connect attempts: first delay is min, each
+// next delay multiplied by multiplier but not greater than max. Non-positive
+// min means DefaultMinDelay, max less than min means min and multiplier less
+// than 1 means 1
+func WithBackoff(min, max time.Duration, multiplier float64) ConnectOption {
+	return func(o *connectOptions) {
+		if min <= 0 {
+			min = DefaultMinDelay
+		}
+		if max < min {
+			max = min
+		}
+		if !(multiplier >= 1) {
+			multiplier = 1
+		}
+		o.minDelay = min
+		o.maxDelay = max
+		o.multiplier = multiplier
+	}
+}
+
+// WithJitter set random delay deviation in range 0..1. The 0.2 means that
+// delay will be randomly changed up to plus or minus 20 percent. Values out
+// of range are clamped to it
+func WithJitter(jitter float64) ConnectOption {
+	return func(o *connectOptions) {
+		switch {
+		case jitter > 1:
+			jitter = 1
+		case !(jitter >= 0):
+			jitter = 0
+		}
+		o.jitter = jitter
+	}
+}
+
+// WithMaxAttempts set maximum number of connect attempts, 0 means unlimited
+func WithMaxAttempts(n int) ConnectOption {
+	return func(o *connectOptions) { o.maxAttempts = n }
+}
+
+// WithAttemptFailed set callback which called after each failed connect
+// attempt
+func WithAttemptFailed(f func(attempt int, err error)) ConnectOption {
+	return func(o *connectOptions) { o.onFail = f }
+}
+
+// WithFlushWindow set Monitor.SendParam flush window. Parameters sent during
+// the window are coalesced and sent to monitor in one packet. By default
+// parameters are sent immediately
+func WithFlushWindow(window time.Duration) ConnectOption {
+	return func(o *connectOptions) { o.flushWindow = window }
+}
+
+// WithAggregateInterval set interval of sending counters and histograms to
+// monitor. Non-positive interval means DefaultAggregateInterval
+func WithAggregateInterval(interval time.Duration) ConnectOption {
+	return func(o *connectOptions) { o.aggInterval = interval }
+}
+
+// connect to monitor with backoff
+func (o *connectOptions) connect(ctx context.Context, teo TeonetInterface,
+	address string) (err error) {
+
+	delay := o.minDelay
+	for attempt := 1; ; attempt++ {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		if err = teo.ConnectTo(address); err == nil {
+			return
+		}
+		if o.onFail != nil {
+			o.onFail(attempt, err)
+		}
+		if o.maxAttempts > 0 && attempt >= o.maxAttempts {
+			err = fmt.Errorf("%w: %v", ErrMaxAttempts, err)
+			return
+		}
+
+		// Wait delay or context done
+		timer := time.NewTimer(o.withJitter(delay))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			err = ctx.Err()
+			return
+		case <-timer.C:
+		}
+
+		// Next delay
+		delay = time.Duration(float64(delay) * o.multiplier)
+		if delay > o.maxDelay {
+			delay = o.maxDelay
+		}
+	}
+}
+
+// withJitter return delay randomly changed by jitter
+func (o *connectOptions) withJitter(delay time.Duration) time.Duration {
+	if o.jitter <= 0 {
+		return delay
+	}
+	return delay + time.Duration((rand.Float64()*2-1)*o.jitter*float64(delay))
+}
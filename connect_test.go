@@ -0,0 +1,68 @@
+package teomon
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failTeonet is fake teonet which ConnectTo always fails
+type failTeonet struct {
+	fakeTeonet
+}
+
+func (t *failTeonet) ConnectTo(address string, attr ...interface{}) error {
+	return errors.New("can't connect")
+}
+
+func TestConnectContext(t *testing.T) {
+
+	teo := &failTeonet{fakeTeonet{address: "client-1", peers: NewPeers()}}
+
+	// Max attempts
+	var attempts int
+	_, err := ConnectContext(context.Background(), teo, "monitor", *NewMetric(),
+		WithBackoff(time.Millisecond, 4*time.Millisecond, 2),
+		WithMaxAttempts(3),
+		WithAttemptFailed(func(attempt int, err error) { attempts = attempt }),
+	)
+	if !errors.Is(err, ErrMaxAttempts) || attempts != 3 {
+		t.Error("wrong max attempts result", err, attempts)
+		return
+	}
+	if !strings.Contains(err.Error(), "can't connect") {
+		t.Error("last connect error lost", err)
+		return
+	}
+
+	// Context cancel
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = ConnectContext(ctx, teo, "monitor", *NewMetric(),
+		WithBackoff(time.Millisecond, 5*time.Millisecond, 2),
+	)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("wrong context cancel result", err)
+		return
+	}
+}
+
+func TestBackoffClamp(t *testing.T) {
+
+	o := newConnectOptions(nil, WithBackoff(0, 0, 0.5), WithJitter(2))
+	if o.minDelay != DefaultMinDelay || o.maxDelay != DefaultMinDelay ||
+		o.multiplier != 1 || o.jitter != 1 {
+		t.Error("wrong clamped backoff", o.minDelay, o.maxDelay, o.multiplier,
+			o.jitter)
+		return
+	}
+
+	o = newConnectOptions(nil, WithBackoff(time.Second, time.Millisecond, 2),
+		WithJitter(-1))
+	if o.maxDelay != time.Second || o.jitter != 0 {
+		t.Error("wrong clamped backoff", o.maxDelay, o.jitter)
+		return
+	}
+}
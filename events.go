@@ -0,0 +1,207 @@
+// Copyright 2021-22 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet v5 monitoring peers change events
+
+package teomon
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// EventType is type of peers change event
+type EventType byte
+
+// Peers change events types
+const (
+	PeerAdded    EventType = iota + 1 // New peer added
+	PeerRemoved                       // Peer removed
+	ParamChanged                      // Peer parameter changed
+	PeerOnline                        // Peer parameter online changed to true
+	PeerOffline                       // Peer parameter online changed to false
+	PeerUpdated                       // Existing peer metric replaced
+)
+
+// String return event type name
+func (t EventType) String() string {
+	switch t {
+	case PeerAdded:
+		return "peer_added"
+	case PeerRemoved:
+		return "peer_removed"
+	case ParamChanged:
+		return "param_changed"
+	case PeerOnline:
+		return "peer_online"
+	case PeerOffline:
+		return "peer_offline"
+	case PeerUpdated:
+		return "peer_updated"
+	}
+	return fmt.Sprintf("event_%d", byte(t))
+}
+
+// MarshalJSON json marshal event type as its name
+func (t EventType) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.String())
+}
+
+// Event is peers change event
+type Event struct {
+	Type    EventType   `json:"type"`
+	Address string      `json:"address"`
+	Name    string      `json:"name,omitempty"`  // Parameter name
+	Old     interface{} `json:"old,omitempty"`   // Old parameter value
+	Value   interface{} `json:"value,omitempty"` // New parameter value
+	Time    time.Time   `json:"time"`
+}
+
+// eventHub deliver events to subscribers
+type eventHub struct {
+	subs map[int]func(Event)
+	next int
+	sync.Mutex
+}
+
+// newEventHub create new event hub
+func newEventHub() *eventHub {
+	return &eventHub{subs: make(map[int]func(Event))}
+}
+
+// Subscribe to peers change events. The f is called for each event in the
+// goroutine which changed Peers, without holding Peers lock, so f may call
+// Peers methods. The f should return quickly. The unsubscribe function
+// remove subscription
+func (p *Peers) Subscribe(f func(Event)) (unsubscribe func()) {
+	h := p.events
+	h.Lock()
+	id := h.next
+	h.next++
+	h.subs[id] = f
+	h.Unlock()
+
+	return func() {
+		h.Lock()
+		defer h.Unlock()
+		delete(h.subs, id)
+	}
+}
+
+// Events subscribe to peers change events. Events are sent to returned
+// channel with buffer size, events are dropped if channel buffer is full.
+// The cancel function unsubscribe and close the channel
+func (p *Peers) Events(size int) (events <-chan Event, cancel func()) {
+	ch := make(chan Event, size)
+	var closed bool
+	var mu sync.Mutex
+
+	unsubscribe := p.Subscribe(func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		if closed {
+			return
+		}
+		select {
+		case ch <- e:
+		default:
+		}
+	})
+
+	cancel = func() {
+		unsubscribe()
+		mu.Lock()
+		defer mu.Unlock()
+		if !closed {
+			closed = true
+			close(ch)
+		}
+	}
+	return ch, cancel
+}
+
+// emit send event to all subscribers
+func (p *Peers) emit(e Event) {
+	if p.events == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	h := p.events
+	h.Lock()
+	subs := make([]func(Event), 0, len(h.subs))
+	for _, f := range h.subs {
+		subs = append(subs, f)
+	}
+	h.Unlock()
+
+	for _, f := range subs {
+		f(e)
+	}
+}
+
+// paramsNotify return peer parameters callback which record history and emit
+// changes events
+func (p *Peers) paramsNotify(address string) func(name string, old, val interface{}) {
+	return func(name string, old, val interface{}) {
+		p.recordHistory(address, name, val)
+		if reflect.DeepEqual(old, val) {
+			return
+		}
+		p.emit(Event{Type: ParamChanged, Address: address, Name: name,
+			Old: old, Value: val})
+
+		if name != ParamOnline {
+			return
+		}
+		switch val {
+		case true:
+			p.emit(Event{Type: PeerOnline, Address: address})
+		case false:
+			p.emit(Event{Type: PeerOffline, Address: address})
+		}
+	}
+}
+
+// EventsHandler return http handler which stream peers change events as
+// Server-Sent Events. Event name is event type and data is event in json
+// format
+func (p *Peers) EventsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		events, cancel := p.Events(64)
+		defer cancel()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case e := <-events:
+				data, err := json.Marshal(e)
+				if err != nil {
+					continue
+				}
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+				flusher.Flush()
+			}
+		}
+	})
+}
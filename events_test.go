@@ -0,0 +1,134 @@
+package teomon
+
+import (
+	"bufio"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventsHandler(t *testing.T) {
+
+	peers := NewPeers()
+	srv := httptest.NewServer(peers.EventsHandler())
+	defer srv.Close()
+
+	resp, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Error("wrong content type", ct)
+		return
+	}
+
+	// Make changes
+	m := NewMetric()
+	m.Address = "a1"
+	peers.Add(m)
+	m.Params.Add(ParamPeers, []byte("uncomparable"))
+	peers.Disconnected("a1")
+	peers.Del("a1")
+
+	// Read events
+	var events []string
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	timeout := time.After(time.Second)
+	for len(events) < 5 {
+		select {
+		case line := <-lines:
+			if strings.HasPrefix(line, "event: ") {
+				events = append(events, strings.TrimPrefix(line, "event: "))
+			}
+		case <-timeout:
+			t.Error("events timeout", events)
+			return
+		}
+	}
+
+	expected := "peer_added param_changed param_changed peer_offline peer_removed"
+	if strings.Join(events, " ") != expected {
+		t.Error("wrong events", events)
+		return
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+
+	peers := NewPeers()
+
+	var events []Event
+	unsubscribe := peers.Subscribe(func(e Event) {
+		// Subscriber can call back into Peers
+		if _, ok := peers.Get(e.Address); ok || e.Type == PeerRemoved {
+			events = append(events, e)
+		}
+	})
+
+	m := NewMetric()
+	m.Address = "a1"
+	peers.Add(m)
+	m.Params.Add("num_users", 1)
+	m.Params.Add("num_users", 2)
+
+	m = NewMetric()
+	m.Address = "a1"
+	peers.Add(m)
+	peers.Del("a1")
+
+	unsubscribe()
+	peers.Add(m)
+
+	var types []string
+	for _, e := range events {
+		types = append(types, e.Type.String())
+	}
+	expected := "peer_added param_changed param_changed peer_updated peer_removed"
+	if strings.Join(types, " ") != expected {
+		t.Error("wrong events", types)
+		return
+	}
+	if e := events[2]; e.Name != "num_users" || e.Old != 1 || e.Value != 2 {
+		t.Error("wrong param changed event", e)
+		return
+	}
+}
+
+func TestUnmarshalBinaryEvents(t *testing.T) {
+
+	src := NewPeers()
+	m := NewMetric()
+	m.Address = "a1"
+	src.Add(m)
+	data, err := src.MarshalBinary()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	peers := NewPeers()
+	var events []string
+	peers.Subscribe(func(e Event) {
+		events = append(events, e.Type.String()+" "+e.Address)
+	})
+	if err := peers.UnmarshalBinary(data); err != nil {
+		t.Error(err)
+		return
+	}
+	peers.Disconnected("a1")
+
+	expected := "peer_added a1, param_changed a1, peer_offline a1"
+	if strings.Join(events, ", ") != expected {
+		t.Error("wrong events", events)
+		return
+	}
+}
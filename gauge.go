@@ -0,0 +1,58 @@
+// Copyright 2021-22 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet v5 monitoring client periodic parameters
+
+package teomon
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrWrongInterval returned to monitor error handler when gauge or collector
+// registered with non-positive interval
+var ErrWrongInterval = errors.New("non-positive interval")
+
+// RegisterGauge register gauge provider function. The fn is called every
+// interval and its result is sent to monitor as parameter with name. All
+// gauges are stopped when Monitor closed. Non-positive interval is rejected
+// with ErrWrongInterval passed to error handler set by OnError
+func (mon *Monitor) RegisterGauge(name string, interval time.Duration, fn func() interface{}) {
+	mon.every(interval, func() {
+		mon.SendParam(name, fn())
+	})
+}
+
+// every execute f every interval until monitor closed. Non-positive interval
+// is passed to error handler and f is not executed
+func (mon *Monitor) every(interval time.Duration, f func()) {
+	if interval <= 0 {
+		mon.error(fmt.Errorf("%w: %v", ErrWrongInterval, interval))
+		return
+	}
+
+	mon.mu.Lock()
+	defer mon.mu.Unlock()
+	select {
+	case <-mon.done:
+		return
+	default:
+	}
+	mon.wg.Add(1)
+	go func() {
+		defer mon.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-mon.done:
+				return
+			case <-ticker.C:
+				f()
+			}
+		}
+	}()
+}
@@ -0,0 +1,61 @@
+package teomon
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRegisterGauge(t *testing.T) {
+
+	peers := NewPeers()
+	teo := &fakeTeonet{address: "client-1", peers: peers}
+	mon := Connect(teo, "monitor", *NewMetric())
+
+	var n int32
+	mon.RegisterGauge("queue", time.Millisecond, func() interface{} {
+		return int(atomic.AddInt32(&n, 1))
+	})
+	time.Sleep(20 * time.Millisecond)
+	mon.Close()
+
+	metric, _ := peers.Get("client-1")
+	val, ok := metric.Params.Get("queue")
+	if !ok || val.(int) < 1 {
+		t.Error("gauge not sent", val)
+		return
+	}
+
+	// Gauge stopped after close
+	sent := atomic.LoadInt32(&n)
+	time.Sleep(10 * time.Millisecond)
+	if atomic.LoadInt32(&n) != sent {
+		t.Error("gauge not stopped after close")
+		return
+	}
+}
+
+func TestRegisterGaugeInterval(t *testing.T) {
+
+	peers := NewPeers()
+	teo := &fakeTeonet{address: "client-1", peers: peers}
+	mon, _ := ConnectContext(context.Background(), teo, "monitor", *NewMetric(),
+		WithAggregateInterval(0))
+	defer mon.Close()
+
+	var errs []error
+	mon.OnError(func(err error) { errs = append(errs, err) })
+
+	// Non-positive intervals are rejected without panic
+	mon.RegisterGauge("queue", 0, func() interface{} { return 1 })
+	mon.CollectRuntime(-time.Second)
+	if len(errs) != 2 || !errors.Is(errs[0], ErrWrongInterval) {
+		t.Error("wrong interval errors", errs)
+		return
+	}
+
+	// Aggregates use default interval
+	mon.Counter("requests").Inc()
+}
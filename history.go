@@ -0,0 +1,230 @@
+// Copyright 2021-22 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet v5 monitoring parameters history
+
+package teomon
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultHistorySize is default number of samples kept for each peer
+// parameter
+const DefaultHistorySize = 1024
+
+// HistoryOptions is peers parameters history options
+type HistoryOptions struct {
+	Size       int           // Max number of samples per peer parameter
+	Retention  time.Duration // Max samples age, 0 - unlimited
+	Resolution time.Duration // Downsampling interval, 0 - keep all samples
+}
+
+// Sample is parameter value at time
+type Sample struct {
+	Time  time.Time
+	Value interface{}
+}
+
+// historyKey is peer parameter history key
+type historyKey struct {
+	address string
+	name    string
+}
+
+// history keep peers parameters samples in ring buffers
+type history struct {
+	opts    HistoryOptions
+	rings   map[historyKey]*ring
+	swept   time.Time // Last expired rings removal time
+	disable func()
+	sync.Mutex
+}
+
+// ring is ring buffer of parameter samples. Buffer grows up to history size
+type ring struct {
+	samples []Sample
+	counts  []int // Number of merged samples, used to average numeric values
+	start   int
+	len     int
+}
+
+// EnableHistory start recording received peers parameters values in memory.
+// Each peer parameter keeps up to opts.Size last samples not older than
+// opts.Retention, samples inside opts.Resolution interval are merged: numeric
+// values are averaged, other values replaced with the last one
+func (p *Peers) EnableHistory(opts HistoryOptions) {
+	if opts.Size <= 0 {
+		opts.Size = DefaultHistorySize
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	if p.history != nil {
+		p.history.disable()
+	}
+	h := &history{opts: opts, rings: make(map[historyKey]*ring)}
+	h.disable = p.Subscribe(func(e Event) {
+		if e.Type == PeerRemoved {
+			h.remove(e.Address)
+		}
+	})
+	p.history = h
+}
+
+// recordHistory add received peer parameter value to history if it enabled
+func (p *Peers) recordHistory(address, name string, value interface{}) {
+	p.RLock()
+	h := p.history
+	p.RUnlock()
+	if h != nil {
+		h.add(address, name, time.Now(), value)
+	}
+}
+
+// DisableHistory stop recording peers parameters values and remove history
+func (p *Peers) DisableHistory() {
+	p.Lock()
+	defer p.Unlock()
+	if p.history != nil {
+		p.history.disable()
+		p.history = nil
+	}
+}
+
+// History return peer parameter samples in time range from - to including
+// bounds. Zero from or to means no bound
+func (p *Peers) History(address, name string, from, to time.Time) (samples []Sample) {
+	p.RLock()
+	h := p.history
+	p.RUnlock()
+	if h == nil {
+		return
+	}
+
+	h.Lock()
+	defer h.Unlock()
+	r, ok := h.rings[historyKey{address, name}]
+	if !ok {
+		return
+	}
+	h.expire(r, time.Now())
+	if r.len == 0 {
+		delete(h.rings, historyKey{address, name})
+		return
+	}
+	for i := 0; i < r.len; i++ {
+		s := r.samples[(r.start+i)%len(r.samples)]
+		if !from.IsZero() && s.Time.Before(from) {
+			continue
+		}
+		if !to.IsZero() && s.Time.After(to) {
+			break
+		}
+		samples = append(samples, s)
+	}
+	return
+}
+
+// add sample to peer parameter history
+func (h *history) add(address, name string, t time.Time, value interface{}) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.sweep(t)
+	key := historyKey{address, name}
+	r, ok := h.rings[key]
+	if !ok {
+		r = &ring{}
+		h.rings[key] = r
+	}
+	h.expire(r, t)
+
+	// Downsample: merge with last sample in the same resolution interval
+	if res := h.opts.Resolution; res > 0 && r.len > 0 {
+		i := (r.start + r.len - 1) % len(r.samples)
+		last := &r.samples[i]
+		if t.Truncate(res).Equal(last.Time.Truncate(res)) {
+			v, okV := numericValue(value)
+			lv, okL := numericValue(last.Value)
+			if okV && okL {
+				n := float64(r.counts[i])
+				value = (lv*n + v) / (n + 1)
+			}
+			last.Time, last.Value = t, value
+			r.counts[i]++
+			return
+		}
+	}
+
+	// Append sample, overwrite the oldest one if buffer is full
+	if r.len == len(r.samples) && r.len < h.opts.Size {
+		r.grow(h.opts.Size)
+	}
+	i := (r.start + r.len) % len(r.samples)
+	if r.len == len(r.samples) {
+		r.start = (r.start + 1) % len(r.samples)
+	} else {
+		r.len++
+	}
+	r.samples[i] = Sample{Time: t, Value: value}
+	r.counts[i] = 1
+}
+
+// expire remove samples older than retention
+func (h *history) expire(r *ring, now time.Time) {
+	if h.opts.Retention <= 0 {
+		return
+	}
+	for r.len > 0 && now.Sub(r.samples[r.start].Time) > h.opts.Retention {
+		r.samples[r.start] = Sample{}
+		r.start = (r.start + 1) % len(r.samples)
+		r.len--
+	}
+}
+
+// sweep remove expired samples from all rings and remove empty rings. It
+// executes not more often than once per retention
+func (h *history) sweep(now time.Time) {
+	if h.opts.Retention <= 0 || now.Sub(h.swept) < h.opts.Retention {
+		return
+	}
+	h.swept = now
+	for key, r := range h.rings {
+		h.expire(r, now)
+		if r.len == 0 {
+			delete(h.rings, key)
+		}
+	}
+}
+
+// remove peer parameters history
+func (h *history) remove(address string) {
+	h.Lock()
+	defer h.Unlock()
+	for key := range h.rings {
+		if key.address == address {
+			delete(h.rings, key)
+		}
+	}
+}
+
+// grow ring buffer capacity twice but not greater than size
+func (r *ring) grow(size int) {
+	n := 2 * len(r.samples)
+	if n == 0 {
+		n = 8
+	}
+	if n > size {
+		n = size
+	}
+	samples := make([]Sample, n)
+	counts := make([]int, n)
+	for i := 0; i < r.len; i++ {
+		j := (r.start + i) % len(r.samples)
+		samples[i], counts[i] = r.samples[j], r.counts[j]
+	}
+	r.samples, r.counts, r.start = samples, counts, 0
+}
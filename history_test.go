@@ -0,0 +1,97 @@
+package teomon
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+
+	peers := NewPeers()
+	peers.EnableHistory(HistoryOptions{Size: 3})
+
+	m := NewMetric()
+	m.Address = "a1"
+	peers.Add(m)
+	for i := 1; i <= 5; i++ {
+		m.Params.Add(ParamPeers, i)
+	}
+
+	// Ring buffer keeps the last samples
+	samples := peers.History("a1", ParamPeers, time.Time{}, time.Time{})
+	if len(samples) != 3 || samples[0].Value != 3 || samples[2].Value != 5 {
+		t.Error("wrong history", samples)
+		return
+	}
+
+	// Time range
+	from := samples[1].Time
+	samples = peers.History("a1", ParamPeers, from, time.Time{})
+	if len(samples) != 2 || samples[0].Value != 4 {
+		t.Error("wrong history range", samples)
+		return
+	}
+
+	// Downsampling and retention
+	peers.EnableHistory(HistoryOptions{Resolution: time.Hour, Retention: time.Hour})
+	h := peers.history
+	now := time.Now().Truncate(time.Hour)
+	h.add("a1", "queue", now.Add(-2*time.Hour), 100)
+	h.add("a1", "queue", now, 1)
+	h.add("a1", "queue", now.Add(time.Second), 2)
+	h.add("a1", "queue", now.Add(2*time.Second), 6)
+	samples = peers.History("a1", "queue", time.Time{}, time.Time{})
+	if len(samples) != 1 || samples[0].Value != 3.0 {
+		t.Error("wrong downsampled history", samples)
+		return
+	}
+
+	peers.DisableHistory()
+	if samples = peers.History("a1", "queue", time.Time{}, time.Time{}); samples != nil {
+		t.Error("history not disabled", samples)
+		return
+	}
+}
+
+func TestHistoryUnchanged(t *testing.T) {
+
+	peers := NewPeers()
+	peers.EnableHistory(HistoryOptions{Retention: time.Hour})
+	h := peers.history
+
+	m := NewMetric()
+	m.Address = "a1"
+	peers.Add(m)
+
+	// Unchanged values are recorded
+	m.Params.Add(ParamPeers, 3)
+	m.Params.Add(ParamPeers, 3)
+	samples := peers.History("a1", ParamPeers, time.Time{}, time.Time{})
+	if len(samples) != 2 || samples[1].Value != 3 {
+		t.Error("unchanged value not recorded", samples)
+		return
+	}
+
+	// Expired rings are removed
+	now := time.Now()
+	h.add("a2", "queue", now.Add(-2*time.Hour), 1)
+	h.add("a1", "queue", now.Add(2*time.Hour), 1)
+	h.Lock()
+	_, ok := h.rings[historyKey{"a2", "queue"}]
+	n := len(h.rings)
+	h.Unlock()
+	if ok || n != 1 {
+		t.Error("expired ring not removed")
+		return
+	}
+
+	// Removed peer history is removed
+	peers.Del("a1")
+	h.Lock()
+	n = len(h.rings)
+	h.Unlock()
+	if n != 0 {
+		t.Error("removed peer history not removed", n)
+		return
+	}
+}
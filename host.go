@@ -0,0 +1,76 @@
+// Copyright 2021-22 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet v5 monitoring client host metrics
+
+package teomon
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Host param constant
+const (
+	ParamLoad1        = "load1"         // load average 1 min, float64
+	ParamLoad5        = "load5"         // load average 5 min, float64
+	ParamLoad15       = "load15"        // load average 15 min, float64
+	ParamMemTotal     = "mem_total"     // total host memory, MB float64
+	ParamMemAvailable = "mem_available" // available host memory, MB float64
+	ParamCPUUsage     = "cpu_usage"     // host CPU usage, percent float64
+	ParamProcRSS      = "proc_rss"      // process resident set size, MB float64
+	ParamProcFDs      = "proc_fds"      // process open file descriptors, int
+)
+
+// CollectHost start sending host metrics to monitor every interval: load
+// average, memory, CPU usage and process RSS and open files. Host metrics are
+// supported on Linux only, on other platforms CollectHost does nothing.
+// Collector is stopped when Monitor closed
+func (mon *Monitor) CollectHost(interval time.Duration) {
+	c := newHostCollector(procRoot)
+	if c == nil {
+		return
+	}
+	mon.every(interval, func() {
+		params, err := c.collect()
+		if err != nil {
+			mon.error(fmt.Errorf("collect host metrics: %w", err))
+		}
+		mon.SendParams(paramsMap(params))
+	})
+}
+
+// hostString return compact string with host parameters or empty string if
+// there is no host parameters
+func hostString(p *Parameters) string {
+	var fields []string
+
+	l1, ok1 := p.Get(ParamLoad1)
+	l5, ok5 := p.Get(ParamLoad5)
+	l15, ok15 := p.Get(ParamLoad15)
+	if ok1 && ok5 && ok15 {
+		fields = append(fields, fmt.Sprintf("load %v %v %v",
+			round(l1, 2), round(l5, 2), round(l15, 2)))
+	}
+	if v, ok := p.Get(ParamCPUUsage); ok {
+		fields = append(fields, fmt.Sprintf("cpu %v%%", round(v, 1)))
+	}
+	avail, okAvail := p.Get(ParamMemAvailable)
+	total, okTotal := p.Get(ParamMemTotal)
+	if okAvail && okTotal {
+		fields = append(fields, fmt.Sprintf("mem %v/%v MB", round(avail, 0), round(total, 0)))
+	}
+	if v, ok := p.Get(ParamProcRSS); ok {
+		fields = append(fields, fmt.Sprintf("rss %v MB", round(v, 1)))
+	}
+	if v, ok := p.Get(ParamProcFDs); ok {
+		fields = append(fields, fmt.Sprintf("fds %v", v))
+	}
+
+	if len(fields) == 0 {
+		return ""
+	}
+	return "host: " + strings.Join(fields, ", ")
+}
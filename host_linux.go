@@ -0,0 +1,181 @@
+//go:build linux
+
+package teomon
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// procRoot is proc filesystem mount point
+const procRoot = "/proc"
+
+// hostCollector read host metrics from proc filesystem
+type hostCollector struct {
+	root      string
+	prevIdle  uint64
+	prevTotal uint64
+}
+
+// newHostCollector create host collector which read proc filesystem mounted
+// to root
+func newHostCollector(root string) *hostCollector {
+	return &hostCollector{root: root}
+}
+
+// collect host metrics. It returns all parameters which was read successfully
+// and first error
+func (c *hostCollector) collect() (params []Parameter, err error) {
+	for _, f := range []func() ([]Parameter, error){
+		c.loadavg, c.meminfo, c.cpu, c.process,
+	} {
+		p, e := f()
+		if e != nil && err == nil {
+			err = e
+		}
+		params = append(params, p...)
+	}
+	return
+}
+
+// loadavg read load average from /proc/loadavg
+func (c *hostCollector) loadavg() (params []Parameter, err error) {
+	data, err := os.ReadFile(filepath.Join(c.root, "loadavg"))
+	if err != nil {
+		return
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 3 {
+		err = errors.New("wrong loadavg format")
+		return
+	}
+	for i, name := range []string{ParamLoad1, ParamLoad5, ParamLoad15} {
+		var val float64
+		if val, err = strconv.ParseFloat(fields[i], 64); err != nil {
+			return
+		}
+		params = append(params, Parameter{Name: name, Value: val})
+	}
+	return
+}
+
+// meminfo read total and available memory from /proc/meminfo
+func (c *hostCollector) meminfo() (params []Parameter, err error) {
+	values, err := c.readKB("meminfo", "MemTotal", "MemAvailable")
+	if err != nil {
+		return
+	}
+	params = []Parameter{
+		{Name: ParamMemTotal, Value: float64(values[0]) / 1024},
+		{Name: ParamMemAvailable, Value: float64(values[1]) / 1024},
+	}
+	return
+}
+
+// cpu calculate CPU usage from /proc/stat since previous call or since boot
+// on first call
+func (c *hostCollector) cpu() (params []Parameter, err error) {
+	f, err := os.Open(filepath.Join(c.root, "stat"))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		err = errors.New("empty stat file")
+		return
+	}
+	fields := strings.Fields(scanner.Text())
+	if len(fields) < 5 || fields[0] != "cpu" {
+		err = errors.New("wrong stat format")
+		return
+	}
+
+	// Fields: user nice system idle iowait irq softirq steal
+	var idle, total uint64
+	for i, field := range fields[1:] {
+		if i >= 8 {
+			break
+		}
+		var val uint64
+		if val, err = strconv.ParseUint(field, 10, 64); err != nil {
+			return
+		}
+		if i == 3 || i == 4 {
+			idle += val
+		}
+		total += val
+	}
+
+	dIdle, dTotal := idle-c.prevIdle, total-c.prevTotal
+	c.prevIdle, c.prevTotal = idle, total
+	if dTotal == 0 {
+		return
+	}
+	usage := 100 * float64(dTotal-dIdle) / float64(dTotal)
+	params = []Parameter{{Name: ParamCPUUsage, Value: usage}}
+	return
+}
+
+// process read process RSS from /proc/self/status and count process open
+// file descriptors in /proc/self/fd
+func (c *hostCollector) process() (params []Parameter, err error) {
+	values, err := c.readKB(filepath.Join("self", "status"), "VmRSS")
+	if err != nil {
+		return
+	}
+	params = append(params, Parameter{Name: ParamProcRSS, Value: float64(values[0]) / 1024})
+
+	fds, err := os.ReadDir(filepath.Join(c.root, "self", "fd"))
+	if err != nil {
+		return
+	}
+	params = append(params, Parameter{Name: ParamProcFDs, Value: len(fds)})
+	return
+}
+
+// readKB read values of keys in kB from proc file with 'Key: value kB' lines
+func (c *hostCollector) readKB(file string, keys ...string) (values []uint64, err error) {
+	f, err := os.Open(filepath.Join(c.root, file))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	found := make(map[string]uint64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, rest, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			continue
+		}
+		val, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		found[key] = val
+	}
+	if err = scanner.Err(); err != nil {
+		return
+	}
+
+	for _, key := range keys {
+		val, ok := found[key]
+		if !ok {
+			err = fmt.Errorf("%s not found in %s", key, file)
+			return
+		}
+		values = append(values, val)
+	}
+	return
+}
@@ -0,0 +1,75 @@
+//go:build linux
+
+package teomon
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHostCollector(t *testing.T) {
+
+	// Create fake proc root
+	root := t.TempDir()
+	files := map[string]string{
+		"loadavg": "0.52 0.58 0.59 1/389 12345\n",
+		"meminfo": "MemTotal:       16384000 kB\nMemFree:         1024000 kB\n" +
+			"MemAvailable:    8192000 kB\n",
+		"stat":        "cpu  100 0 100 700 100 0 0 0 0 0\ncpu0 100 0 100 700 100 0 0 0 0 0\n",
+		"self/status": "Name:\ttest\nVmRSS:\t   20480 kB\n",
+		"self/fd/0":   "",
+		"self/fd/1":   "",
+		"self/fd/2":   "",
+	}
+	for name, data := range files {
+		name = filepath.Join(root, name)
+		os.MkdirAll(filepath.Dir(name), 0755)
+		if err := os.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	c := newHostCollector(root)
+	params, err := c.collect()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	p := &Parameters{m: make(map[string]interface{})}
+	for _, par := range params {
+		p.Add(par.Name, par.Value)
+	}
+
+	expected := map[string]interface{}{
+		ParamLoad1:        0.52,
+		ParamLoad15:       0.59,
+		ParamMemTotal:     16000.0,
+		ParamMemAvailable: 8000.0,
+		ParamCPUUsage:     20.0,
+		ParamProcRSS:      20.0,
+		ParamProcFDs:      3,
+	}
+	for name, val := range expected {
+		if v, _ := p.Get(name); v != val {
+			t.Error("wrong host parameter", name, v)
+			return
+		}
+	}
+
+	// CPU usage calculated since previous call
+	os.WriteFile(filepath.Join(root, "stat"),
+		[]byte("cpu  150 0 150 800 100 0 0 0 0 0\n"), 0644)
+	params, _ = c.cpu()
+	if len(params) != 1 || params[0].Value != 50.0 {
+		t.Error("wrong cpu usage", params)
+		return
+	}
+
+	if str := hostString(p); !strings.HasPrefix(str, "host: load 0.52 0.58 0.59") {
+		t.Error("wrong host parameters render:", str)
+		return
+	}
+}
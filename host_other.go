@@ -0,0 +1,15 @@
+//go:build !linux
+
+package teomon
+
+// procRoot is proc filesystem mount point
+const procRoot = ""
+
+// hostCollector is no-op host collector on platforms without proc filesystem
+type hostCollector struct{}
+
+// newHostCollector return nil, host metrics are not supported
+func newHostCollector(root string) *hostCollector { return nil }
+
+// collect does nothing
+func (c *hostCollector) collect() (params []Parameter, err error) { return }
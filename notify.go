@@ -0,0 +1,291 @@
+// Copyright 2021-22 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet v5 monitoring alerts notifiers
+
+package teomon
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited returned by notifiers when notification dropped by rate
+// limit
+var ErrRateLimited = errors.New("notification rate limited")
+
+// NotifyPolicy is notifier retry and rate limit policy. Rate limit is
+// applied separately to each rule, peer address and alert state
+type NotifyPolicy struct {
+	Retries     int           // Number of retries after failed attempt
+	RetryDelay  time.Duration // Delay between retries
+	MinInterval time.Duration // Minimum interval between same notifications
+
+	last map[notifyKey]time.Time
+	mu   sync.Mutex
+}
+
+// notifyKey is rate limit key
+type notifyKey struct {
+	rule, address string
+	state         AlertState
+}
+
+// do execute notification f of alert a with policy retries and rate limit
+func (p *NotifyPolicy) do(ctx context.Context, a Alert, f func() error) (err error) {
+
+	// Rate limit
+	key := notifyKey{a.Rule, a.Address, a.State}
+	if p.MinInterval > 0 {
+		p.mu.Lock()
+		last, ok := p.last[key]
+		p.mu.Unlock()
+		if ok && time.Since(last) < p.MinInterval {
+			return ErrRateLimited
+		}
+	}
+
+	// Retries
+	for attempt := 0; ; attempt++ {
+		if err = f(); err == nil || attempt >= p.Retries {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(p.RetryDelay):
+		}
+	}
+	if err != nil || p.MinInterval <= 0 {
+		return
+	}
+
+	// Remember successful notification time and remove expired ones
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	if p.last == nil {
+		p.last = make(map[notifyKey]time.Time)
+	}
+	for k, t := range p.last {
+		if now.Sub(t) >= p.MinInterval {
+			delete(p.last, k)
+		}
+	}
+	p.last[key] = now
+	return
+}
+
+// WebhookNotifier post alerts in json format to webhook url
+type WebhookNotifier struct {
+	URL     string            // Webhook url
+	Headers map[string]string // Additional http request headers
+	Client  *http.Client      // Http client, http.DefaultClient if nil
+	NotifyPolicy
+}
+
+// NewWebhookNotifier create new webhook notifier
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{URL: url}
+}
+
+// Notify post alert to webhook
+func (n *WebhookNotifier) Notify(ctx context.Context, a Alert) error {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+
+	client := n.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return n.do(ctx, a, func() (err error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL,
+			bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range n.Headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, resp.Body)
+		if resp.StatusCode/100 != 2 {
+			err = fmt.Errorf("webhook: %s", resp.Status)
+		}
+		return
+	})
+}
+
+// SMTPNotifier send alerts by email
+type SMTPNotifier struct {
+	Addr    string        // SMTP server address host:port
+	Auth    smtp.Auth     // SMTP authentication, may be nil
+	From    string        // Sender address
+	To      []string      // Recipients addresses
+	Timeout time.Duration // Send timeout, DefaultSMTPTimeout if 0
+	NotifyPolicy
+}
+
+// DefaultSMTPTimeout is default SMTPNotifier send timeout
+const DefaultSMTPTimeout = 30 * time.Second
+
+// NewSMTPNotifier create new SMTP notifier
+func NewSMTPNotifier(addr string, auth smtp.Auth, from string, to ...string) *SMTPNotifier {
+	return &SMTPNotifier{Addr: addr, Auth: auth, From: from, To: to}
+}
+
+// Notify send alert email
+func (n *SMTPNotifier) Notify(ctx context.Context, a Alert) error {
+	data, err := json.MarshalIndent(a, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	msg := new(bytes.Buffer)
+	fmt.Fprintf(msg, "From: %s\r\n", n.From)
+	fmt.Fprintf(msg, "To: %s\r\n", strings.Join(n.To, ", "))
+	fmt.Fprintf(msg, "Subject: [teomon] %s: %s %s\r\n", a.State, a.Rule, a.Address)
+	fmt.Fprintf(msg, "Content-Type: application/json; charset=utf-8\r\n\r\n")
+	msg.Write(data)
+	msg.WriteString("\r\n")
+
+	return n.do(ctx, a, func() error {
+		return n.sendMail(ctx, msg.Bytes())
+	})
+}
+
+// sendMail send message like smtp.SendMail does. Connection is closed when
+// timeout expired or context done
+func (n *SMTPNotifier) sendMail(ctx context.Context, msg []byte) (err error) {
+	timeout := n.Timeout
+	if timeout <= 0 {
+		timeout = DefaultSMTPTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", n.Addr)
+	if err != nil {
+		return
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	host, _, _ := net.SplitHostPort(n.Addr)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err = c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return
+		}
+	}
+	if n.Auth != nil {
+		if ok, _ := c.Extension("AUTH"); ok {
+			if err = c.Auth(n.Auth); err != nil {
+				return
+			}
+		}
+	}
+	if err = c.Mail(n.From); err != nil {
+		return
+	}
+	for _, to := range n.To {
+		if err = c.Rcpt(to); err != nil {
+			return
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return
+	}
+	if _, err = w.Write(msg); err != nil {
+		return
+	}
+	if err = w.Close(); err != nil {
+		return
+	}
+	return c.Quit()
+}
+
+// ScriptNotifier execute local script for alerts. Alert is passed to script
+// in environment variables: TEOMON_RULE, TEOMON_EXPR, TEOMON_STATE,
+// TEOMON_ADDRESS, TEOMON_APP, TEOMON_PARAM, TEOMON_VALUE, TEOMON_SINCE and
+// TEOMON_ALERT with alert in json format
+type ScriptNotifier struct {
+	Path string   // Script path
+	Args []string // Script arguments
+	NotifyPolicy
+}
+
+// NewScriptNotifier create new script notifier
+func NewScriptNotifier(path string, args ...string) *ScriptNotifier {
+	return &ScriptNotifier{Path: path, Args: args}
+}
+
+// Notify execute script with alert in environment variables
+func (n *ScriptNotifier) Notify(ctx context.Context, a Alert) error {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	env := append(os.Environ(),
+		"TEOMON_RULE="+a.Rule,
+		"TEOMON_EXPR="+a.Expr,
+		"TEOMON_STATE="+a.State.String(),
+		"TEOMON_ADDRESS="+a.Address,
+		"TEOMON_APP="+a.Metric.AppShort,
+		"TEOMON_PARAM="+a.Param,
+		fmt.Sprintf("TEOMON_VALUE=%v", a.Value),
+		"TEOMON_SINCE="+a.Since.Format(time.RFC3339),
+		"TEOMON_ALERT="+string(data),
+	)
+
+	return n.do(ctx, a, func() error {
+		cmd := exec.CommandContext(ctx, n.Path, n.Args...)
+		cmd.Env = env
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("script %s: %w: %s", n.Path, err,
+				strings.TrimSpace(string(out)))
+		}
+		return nil
+	})
+}
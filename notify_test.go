@@ -0,0 +1,230 @@
+package teomon
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testAlert return alert used in notifiers tests
+func testAlert() Alert {
+	m := NewMetric()
+	m.Address = "a1"
+	m.AppShort = "test-app"
+	m.Params.Add(ParamOnline, false)
+	return Alert{Rule: "offline", Expr: "online == false", State: AlertFiring,
+		Address: "a1", Param: ParamOnline, Value: false, Since: time.Now(),
+		Time: time.Now(), Metric: newPmetric(m)}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+
+	var requests int
+	var alert map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if requests == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			json.NewDecoder(r.Body).Decode(&alert)
+		}))
+	defer srv.Close()
+
+	n := NewWebhookNotifier(srv.URL)
+	n.Retries = 1
+	n.MinInterval = time.Hour
+
+	ctx := context.Background()
+	if err := n.Notify(ctx, testAlert()); err != nil {
+		t.Error(err)
+		return
+	}
+	if requests != 2 || alert["rule"] != "offline" || alert["state"] != "firing" {
+		t.Error("wrong webhook request", requests, alert)
+		return
+	}
+	metric, _ := alert["metric"].(map[string]interface{})
+	params, _ := metric["Params"].(map[string]interface{})
+	if params[ParamOnline] != false {
+		t.Error("wrong webhook metric", metric)
+		return
+	}
+
+	// Rate limit
+	if err := n.Notify(ctx, testAlert()); !errors.Is(err, ErrRateLimited) {
+		t.Error("notification not rate limited", err)
+		return
+	}
+
+	// Rate limit is per rule, address and state
+	a := testAlert()
+	a.Address = "a2"
+	if err := n.Notify(ctx, a); err != nil {
+		t.Error("other peer notification rate limited", err)
+		return
+	}
+	a = testAlert()
+	a.State = AlertResolved
+	if err := n.Notify(ctx, a); err != nil {
+		t.Error("resolved notification rate limited", err)
+		return
+	}
+}
+
+func TestNotifyPolicyFailed(t *testing.T) {
+
+	p := NotifyPolicy{MinInterval: time.Hour}
+	ctx := context.Background()
+	errSend := errors.New("send failed")
+
+	// Failed notification does not start rate limit interval
+	if err := p.do(ctx, testAlert(), func() error { return errSend }); err != errSend {
+		t.Error("wrong failed notification error", err)
+		return
+	}
+	if err := p.do(ctx, testAlert(), func() error { return nil }); err != nil {
+		t.Error("notification after failed one rate limited", err)
+		return
+	}
+}
+
+func TestSMTPNotifier(t *testing.T) {
+
+	// Local SMTP stand-in
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer ln.Close()
+	message := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		write := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		write("220 localhost ESMTP")
+		var data []string
+		for inData := false; ; {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			switch {
+			case inData && line == ".":
+				inData = false
+				message <- strings.Join(data, "\n")
+				write("250 OK")
+			case inData:
+				data = append(data, line)
+			case strings.HasPrefix(line, "EHLO"), strings.HasPrefix(line, "HELO"):
+				write("250 localhost")
+			case line == "DATA":
+				inData = true
+				write("354 Go ahead")
+			case line == "QUIT":
+				write("221 Bye")
+				return
+			default:
+				write("250 OK")
+			}
+		}
+	}()
+
+	n := NewSMTPNotifier(ln.Addr().String(), nil, "teomon@example.com",
+		"ops@example.com")
+	if err := n.Notify(context.Background(), testAlert()); err != nil {
+		t.Error(err)
+		return
+	}
+	msg := <-message
+	if !strings.Contains(msg, "Subject: [teomon] firing: offline a1") ||
+		!strings.Contains(msg, `"rule": "offline"`) {
+		t.Error("wrong email message:\n" + msg)
+		return
+	}
+}
+
+func TestSMTPNotifierTimeout(t *testing.T) {
+
+	// Hung SMTP server which accepts connections and never answers
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	n := NewSMTPNotifier(ln.Addr().String(), nil, "teomon@example.com",
+		"ops@example.com")
+	n.Timeout = 50 * time.Millisecond
+	start := time.Now()
+	if err := n.Notify(context.Background(), testAlert()); err == nil ||
+		time.Since(start) > time.Second {
+		t.Error("hung server send not timed out", err, time.Since(start))
+		return
+	}
+
+	// Context done
+	n.Timeout = 0
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	if err := n.Notify(ctx, testAlert()); err == nil || time.Since(start) > time.Second {
+		t.Error("hung server send not canceled", err, time.Since(start))
+		return
+	}
+}
+
+func TestScriptNotifier(t *testing.T) {
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	script := filepath.Join(dir, "notify.sh")
+	os.WriteFile(script, []byte("#!/bin/sh\n"+
+		"echo \"$TEOMON_STATE $TEOMON_RULE $TEOMON_ADDRESS $TEOMON_APP $1\" > "+
+		out+"\n"), 0755)
+
+	n := NewScriptNotifier(script, "arg")
+	if err := n.Notify(context.Background(), testAlert()); err != nil {
+		t.Error(err)
+		return
+	}
+	data, _ := os.ReadFile(out)
+	if string(data) != "firing offline a1 test-app arg\n" {
+		t.Error("wrong script output", string(data))
+		return
+	}
+
+	// Script error with retries
+	n = NewScriptNotifier(filepath.Join(dir, "not-exists"))
+	n.Retries = 2
+	if err := n.Notify(context.Background(), testAlert()); err == nil {
+		t.Error("script error not returned")
+		return
+	}
+}
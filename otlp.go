@@ -0,0 +1,207 @@
+// Copyright 2021-22 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet v5 monitoring OpenTelemetry (OTLP/HTTP) export
+
+package teomon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// DefaultOTLPEndpoint is default OTLP/HTTP collector metrics endpoint
+const DefaultOTLPEndpoint = "http://localhost:4318/v1/metrics"
+
+// OTLPPrefix is prefix of OTLP metrics names
+const OTLPPrefix = "teomon."
+
+// OTLPExporter export peers metrics to OpenTelemetry collector with OTLP/HTTP
+// protocol in JSON encoding. Every peer is exported as resource with
+// attributes from Metric fields and one gauge per numeric parameter
+type OTLPExporter struct {
+	Endpoint string            // Collector metrics endpoint url
+	Headers  map[string]string // Additional http request headers
+	Client   *http.Client      // Http client, http.DefaultClient if nil
+}
+
+// NewOTLPExporter create new OTLP exporter. If endpoint is empty the
+// DefaultOTLPEndpoint is used
+func NewOTLPExporter(endpoint string) *OTLPExporter {
+	if endpoint == "" {
+		endpoint = DefaultOTLPEndpoint
+	}
+	return &OTLPExporter{Endpoint: endpoint}
+}
+
+// OTLP JSON messages
+type (
+	otlpRequest struct {
+		ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+	}
+	otlpResourceMetrics struct {
+		Resource     otlpResource       `json:"resource"`
+		ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue string `json:"stringValue"`
+	}
+	otlpScopeMetrics struct {
+		Scope   otlpScope    `json:"scope"`
+		Metrics []otlpMetric `json:"metrics"`
+	}
+	otlpScope struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	}
+	otlpMetric struct {
+		Name  string    `json:"name"`
+		Unit  string    `json:"unit,omitempty"`
+		Gauge otlpGauge `json:"gauge"`
+	}
+	otlpGauge struct {
+		DataPoints []otlpDataPoint `json:"dataPoints"`
+	}
+	otlpDataPoint struct {
+		StartTimeUnixNano string  `json:"startTimeUnixNano,omitempty"`
+		TimeUnixNano      string  `json:"timeUnixNano"`
+		AsDouble          float64 `json:"asDouble"`
+	}
+)
+
+// Export send current peers metrics to collector
+func (e *OTLPExporter) Export(ctx context.Context, p *Peers) (err error) {
+	data, err := json.Marshal(p.otlpRequest(time.Now()))
+	if err != nil {
+		return
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.Endpoint,
+		bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.Headers {
+		req.Header.Set(k, v)
+	}
+
+	client := e.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode/100 != 2 {
+		err = fmt.Errorf("otlp export: %s", resp.Status)
+	}
+	return
+}
+
+// Run export peers metrics every interval until context done. Export errors
+// are passed to onError callback if it is not nil
+func (e *OTLPExporter) Run(ctx context.Context, p *Peers, interval time.Duration,
+	onError func(err error)) error {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if err := e.Export(ctx, p); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+// otlpRequest return OTLP export request with peers metrics snapshot
+func (p *Peers) otlpRequest(now time.Time) (req otlpRequest) {
+	ts := strconv.FormatInt(now.UnixNano(), 10)
+
+	p.Each(func(m *Metric) {
+		var start string
+		if !m.AppStartTime.IsZero() {
+			start = strconv.FormatInt(m.AppStartTime.UnixNano(), 10)
+		}
+		point := func(v float64) otlpGauge {
+			return otlpGauge{DataPoints: []otlpDataPoint{{
+				StartTimeUnixNano: start,
+				TimeUnixNano:      ts,
+				AsDouble:          v,
+			}}}
+		}
+
+		// Resource attributes
+		host, _ := m.Params.Get(ParamHost)
+		hostName, _ := host.(string)
+		id, _ := m.Params.Get(ParamMachineID)
+		machineID, _ := id.(string)
+		var attrs []otlpAttribute
+		for _, a := range [][2]string{
+			{"service.name", m.AppShort},
+			{"service.version", m.AppVersion},
+			{"service.instance.id", m.Address},
+			{"teonet.address", m.Address},
+			{"teonet.app_name", m.AppName},
+			{"teonet.version", m.TeoVersion},
+			{"host.name", hostName},
+			{"host.id", machineID},
+		} {
+			if a[1] == "" {
+				continue
+			}
+			attrs = append(attrs, otlpAttribute{a[0], otlpValue{a[1]}})
+		}
+
+		// One gauge per numeric parameter. NaN and Inf values can't be
+		// encoded to json number so they are skipped
+		var metrics []otlpMetric
+		if start != "" {
+			metrics = append(metrics, otlpMetric{Name: OTLPPrefix + "uptime",
+				Unit: "s", Gauge: point(now.Sub(m.AppStartTime).Seconds())})
+		}
+		m.Params.Each(func(name string, value interface{}) {
+			f, ok := numericValue(value)
+			if ok && !math.IsNaN(f) && !math.IsInf(f, 0) {
+				metrics = append(metrics, otlpMetric{Name: OTLPPrefix + name,
+					Gauge: point(f)})
+			}
+		})
+		sort.Slice(metrics, func(i, j int) bool {
+			return metrics[i].Name < metrics[j].Name
+		})
+
+		req.ResourceMetrics = append(req.ResourceMetrics, otlpResourceMetrics{
+			Resource: otlpResource{Attributes: attrs},
+			ScopeMetrics: []otlpScopeMetrics{{
+				Scope:   otlpScope{Name: "teomon", Version: version},
+				Metrics: metrics,
+			}},
+		})
+	})
+	return
+}
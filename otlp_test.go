@@ -0,0 +1,100 @@
+package teomon
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOTLPExporter(t *testing.T) {
+
+	var req otlpRequest
+	collector := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/v1/metrics" || r.Header.Get("X-Token") != "123" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+		}))
+	defer collector.Close()
+
+	peers := NewPeers()
+	m := NewMetric()
+	m.Address = "qUzILis"
+	m.AppShort = "test-app"
+	peers.Add(m)
+	m.Params.Add(ParamPeers, 3)
+	m.Params.Add(ParamHost, "host-1")
+
+	e := NewOTLPExporter(collector.URL + "/v1/metrics")
+	e.Headers = map[string]string{"X-Token": "123"}
+	if err := e.Export(context.Background(), peers); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(req.ResourceMetrics) != 1 {
+		t.Error("wrong number of resources", len(req.ResourceMetrics))
+		return
+	}
+	rm := req.ResourceMetrics[0]
+	attrs := make(map[string]string)
+	for _, a := range rm.Resource.Attributes {
+		attrs[a.Key] = a.Value.StringValue
+	}
+	if attrs["service.name"] != "test-app" || attrs["host.name"] != "host-1" ||
+		attrs["teonet.address"] != "qUzILis" {
+		t.Error("wrong resource attributes", attrs)
+		return
+	}
+	gauges := make(map[string]float64)
+	for _, metric := range rm.ScopeMetrics[0].Metrics {
+		gauges[metric.Name] = metric.Gauge.DataPoints[0].AsDouble
+	}
+	if gauges["teomon.online"] != 1 || gauges["teomon.peers"] != 3 {
+		t.Error("wrong gauges", gauges)
+		return
+	}
+
+	// Collector error
+	e.Headers = nil
+	if err := e.Export(context.Background(), peers); err == nil {
+		t.Error("collector error not returned")
+		return
+	}
+}
+
+func TestOTLPNonFinite(t *testing.T) {
+
+	peers := NewPeers()
+	m := NewMetric()
+	m.Address = "a1"
+	peers.Add(m)
+	m.Params.Add("nan", math.NaN())
+	m.Params.Add("inf", math.Inf(1))
+	m.Params.Add("num_users", 10)
+
+	var received bool
+	collector := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) { received = true }))
+	defer collector.Close()
+
+	e := NewOTLPExporter(collector.URL)
+	if err := e.Export(context.Background(), peers); err != nil || !received {
+		t.Error("export with non-finite values failed", err)
+		return
+	}
+
+	for _, metric := range peers.otlpRequest(time.Now()).ResourceMetrics[0].
+		ScopeMetrics[0].Metrics {
+		if metric.Name == OTLPPrefix+"nan" || metric.Name == OTLPPrefix+"inf" {
+			t.Error("non-finite value exported", metric.Name)
+			return
+		}
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2021-22 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet v5 monitoring Prometheus export
+
+package teomon
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PrometheusPrefix is prefix of Prometheus metrics names
+const PrometheusPrefix = "teomon_"
+
+// prometheusSample is one Prometheus series value
+type prometheusSample struct {
+	labels string
+	value  float64
+}
+
+// WritePrometheus write peers metrics in Prometheus text exposition format.
+// Every peer is exported as series labelled with address, app_short,
+// app_version, teo_version and host, with gauges uptime_seconds and every
+// numeric parameter including online and peers. Parameters which names
+// collide after conversion to Prometheus name are exported once
+func (p *Peers) WritePrometheus(w io.Writer) (err error) {
+	series := make(map[string][]prometheusSample)
+	now := time.Now()
+
+	p.Each(func(m *Metric) {
+		host, _ := m.Params.Get(ParamHost)
+		hostName, _ := host.(string)
+		labels := prometheusLabels(
+			"address", m.Address,
+			"app_short", m.AppShort,
+			"app_version", m.AppVersion,
+			"teo_version", m.TeoVersion,
+			"host", hostName,
+		)
+
+		// Series names used by peer. Duplicate series are rejected by
+		// Prometheus, so parameters which names collide with built-in or
+		// other parameter series are skipped
+		used := make(map[string]bool)
+		add := func(name string, f float64) {
+			name = PrometheusPrefix + name
+			if used[name] {
+				return
+			}
+			used[name] = true
+			series[name] = append(series[name], prometheusSample{labels, f})
+		}
+
+		if !m.AppStartTime.IsZero() {
+			add("uptime_seconds", now.Sub(m.AppStartTime).Seconds())
+		}
+
+		// Parameters with valid names take precedence over converted ones
+		type param struct {
+			name  string
+			value float64
+			valid bool
+		}
+		var params []param
+		m.Params.Each(func(name string, value interface{}) {
+			if f, ok := numericValue(value); ok {
+				params = append(params, param{name, f, prometheusName(name) == name})
+			}
+		})
+		sort.Slice(params, func(i, j int) bool {
+			if params[i].valid != params[j].valid {
+				return params[i].valid
+			}
+			return params[i].name < params[j].name
+		})
+		for _, par := range params {
+			add(prometheusName(par.name), par.value)
+		}
+	})
+
+	// Write series sorted by name and labels
+	names := make([]string, 0, len(series))
+	for name := range series {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	bw := bufio.NewWriter(w)
+	for _, name := range names {
+		samples := series[name]
+		sort.Slice(samples, func(i, j int) bool {
+			return samples[i].labels < samples[j].labels
+		})
+		fmt.Fprintf(bw, "# TYPE %s gauge\n", name)
+		for _, s := range samples {
+			fmt.Fprintf(bw, "%s{%s} %s\n", name, s.labels, prometheusFloat(s.value))
+		}
+	}
+	return bw.Flush()
+}
+
+// PrometheusHandler return http handler which serves peers metrics in
+// Prometheus text exposition format
+func (p *Peers) PrometheusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		p.WritePrometheus(w)
+	})
+}
+
+// prometheusName return valid Prometheus metric name made from parameter name
+func prometheusName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9',
+			r == '_', r == ':':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// prometheusLabels return Prometheus labels string from name, value pairs
+func prometheusLabels(pairs ...string) string {
+	var labels []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		labels = append(labels, fmt.Sprintf("%s=\"%s\"", pairs[i],
+			prometheusEscape.Replace(pairs[i+1])))
+	}
+	return strings.Join(labels, ",")
+}
+
+// prometheusEscape escape Prometheus label value
+var prometheusEscape = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// prometheusFloat format Prometheus sample value
+func prometheusFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,90 @@
+package teomon
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWritePrometheus(t *testing.T) {
+
+	peers := NewPeers()
+	m := NewMetric()
+	m.Address = "qUzILis"
+	m.AppShort = "test-app"
+	m.AppVersion = "0.0.1"
+	m.TeoVersion = "0.5.0"
+	m.AppStartTime = time.Now().Add(-time.Minute)
+	peers.Add(m)
+	m.Params.Add(ParamPeers, 3)
+	m.Params.Add(ParamHost, `host "1"`)
+	m.Params.Add("queue.depth", 1.5)
+	m.Params.Add("status", "ok")
+
+	srv := httptest.NewServer(peers.PrometheusHandler())
+	defer srv.Close()
+	resp, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	out := string(data)
+
+	labels := `{address="qUzILis",app_short="test-app",app_version="0.0.1",` +
+		`teo_version="0.5.0",host="host \"1\""}`
+	for _, line := range []string{
+		"# TYPE teomon_online gauge",
+		"teomon_online" + labels + " 1",
+		"teomon_peers" + labels + " 3",
+		"teomon_queue_depth" + labels + " 1.5",
+		"teomon_uptime_seconds" + labels + " 6",
+	} {
+		if !strings.Contains(out, line) {
+			t.Error("line not found:", line, "\n"+out)
+			return
+		}
+	}
+	if strings.Contains(out, "teomon_status") {
+		t.Error("non numeric parameter exported\n" + out)
+		return
+	}
+}
+
+func TestWritePrometheusCollisions(t *testing.T) {
+
+	peers := NewPeers()
+	m := NewMetric()
+	m.Address = "a1"
+	m.AppStartTime = time.Now().Add(-time.Minute)
+	peers.Add(m)
+	m.Params.Add("queue.depth", 1)
+	m.Params.Add("queue_depth", 2)
+	m.Params.Add("queue-depth", 3)
+	m.Params.Add("uptime_seconds", 4)
+
+	buf := new(strings.Builder)
+	if err := peers.WritePrometheus(buf); err != nil {
+		t.Error(err)
+		return
+	}
+	out := buf.String()
+
+	for name, expected := range map[string]int{
+		"teomon_queue_depth{":    1,
+		"teomon_uptime_seconds{": 1,
+	} {
+		if n := strings.Count(out, name); n != expected {
+			t.Error("wrong number of series", name, n, "\n"+out)
+			return
+		}
+	}
+	if !strings.Contains(out, `teomon_queue_depth{address="a1"`) ||
+		!strings.Contains(out, "} 2\n") || strings.Contains(out, "} 4\n") {
+		t.Error("wrong collided series values\n" + out)
+		return
+	}
+}
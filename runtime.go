@@ -0,0 +1,98 @@
+// Copyright 2021-22 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet v5 monitoring client go runtime metrics
+
+package teomon
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Runtime param constant
+const (
+	ParamGoroutines = "goroutines" // number of goroutines, int
+	ParamHeapAlloc  = "heap_alloc" // allocated heap objects, MB float64
+	ParamHeapSys    = "heap_sys"   // heap memory obtained from OS, MB float64
+	ParamNumGC      = "num_gc"     // number of completed GC cycles, uint32
+	ParamGCPause    = "gc_pause"   // last GC pause, ms float64
+	ParamUptime     = "uptime"     // application uptime, seconds float64
+)
+
+// CollectRuntime start sending go runtime metrics to monitor every interval:
+// number of goroutines, heap, GC and application uptime calculated from
+// Metric.AppStartTime. Collector is stopped when Monitor closed
+func (mon *Monitor) CollectRuntime(interval time.Duration) {
+	mon.every(interval, func() {
+		mon.SendParams(paramsMap(mon.runtimeParams()))
+	})
+}
+
+// runtimeParams return current go runtime parameters
+func (mon *Monitor) runtimeParams() []Parameter {
+	const mb = 1024 * 1024
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	var pause float64
+	if ms.NumGC > 0 {
+		pause = float64(ms.PauseNs[(ms.NumGC+255)%256]) / float64(time.Millisecond)
+	}
+
+	return []Parameter{
+		{Name: ParamGoroutines, Value: runtime.NumGoroutine()},
+		{Name: ParamHeapAlloc, Value: float64(ms.HeapAlloc) / mb},
+		{Name: ParamHeapSys, Value: float64(ms.HeapSys) / mb},
+		{Name: ParamNumGC, Value: ms.NumGC},
+		{Name: ParamGCPause, Value: pause},
+		{Name: ParamUptime, Value: time.Since(mon.start).Seconds()},
+	}
+}
+
+// runtimeString return compact string with runtime parameters or empty
+// string if there is no runtime parameters
+func runtimeString(p *Parameters) string {
+	var fields []string
+
+	if v, ok := p.Get(ParamGoroutines); ok {
+		fields = append(fields, fmt.Sprintf("goroutines %v", v))
+	}
+	alloc, okAlloc := p.Get(ParamHeapAlloc)
+	sys, okSys := p.Get(ParamHeapSys)
+	if okAlloc && okSys {
+		fields = append(fields, fmt.Sprintf("heap %v/%v MB", round(alloc, 1), round(sys, 1)))
+	}
+	if v, ok := p.Get(ParamNumGC); ok {
+		gc := fmt.Sprintf("gc %v", v)
+		if pause, ok := p.Get(ParamGCPause); ok {
+			gc += fmt.Sprintf(" (%v ms)", round(pause, 2))
+		}
+		fields = append(fields, gc)
+	}
+	if v, ok := p.Get(ParamUptime); ok {
+		if sec, ok := v.(float64); ok {
+			v = (time.Duration(sec) * time.Second).String()
+		}
+		fields = append(fields, fmt.Sprintf("uptime %v", v))
+	}
+
+	if len(fields) == 0 {
+		return ""
+	}
+	return "runtime: " + strings.Join(fields, ", ")
+}
+
+// round return float64 value v formatted with prec digits after point, other
+// values returned as is
+func round(v interface{}, prec int) interface{} {
+	if f, ok := v.(float64); ok {
+		return strconv.FormatFloat(f, 'f', prec, 64)
+	}
+	return v
+}
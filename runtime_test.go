@@ -0,0 +1,41 @@
+package teomon
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCollectRuntime(t *testing.T) {
+
+	peers := NewPeers()
+	teo := &fakeTeonet{address: "client-1", peers: peers}
+	m := NewMetric()
+	m.AppShort = "runtime-app"
+	m.AppStartTime = time.Now().Add(-time.Hour)
+	mon := Connect(teo, "monitor", *m)
+
+	mon.CollectRuntime(time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	mon.Close()
+
+	metric, _ := peers.Get("client-1")
+	for _, name := range []string{ParamGoroutines, ParamHeapAlloc,
+		ParamHeapSys, ParamNumGC, ParamGCPause, ParamUptime} {
+		if _, ok := metric.Params.Get(name); !ok {
+			t.Error("runtime parameter not sent:", name)
+			return
+		}
+	}
+	if uptime, _ := metric.Params.Get(ParamUptime); uptime.(float64) < 3600 {
+		t.Error("wrong uptime", uptime)
+		return
+	}
+
+	str := peers.String()
+	if !strings.Contains(str, "runtime: goroutines") ||
+		strings.Contains(str, ParamHeapAlloc+":") {
+		t.Error("wrong runtime parameters render:\n" + str)
+		return
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2021-22 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet v5 monitoring server side
+
+package teomon
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrUnknownPeer returned by Process when parameter received from peer which
+// did not send its metric yet
+var ErrUnknownPeer = errors.New("unknown peer")
+
+// Process decode command received from monitoring client and update Peers.
+// The from is teonet address of sender, cmd is command byte (CmdMetric,
+// CmdParameter, CmdParameters or CmdAggregates) and data is command data without command byte
+func (p *Peers) Process(from string, cmd byte, data []byte) (err error) {
+	switch cmd {
+
+	// Metric received: add or update peer
+	case CmdMetric:
+		m := NewMetric()
+		if err = m.UnmarshalBinary(data); err != nil {
+			return
+		}
+		m.Address = from
+		m.New = true
+		m.aggs = nil
+		old, ok := p.Get(from)
+		if ok {
+			p.RLock()
+			m.New = old.New
+			m.aggs = old.aggs
+			p.RUnlock()
+		}
+		p.Add(m)
+		m.Params.Add(ParamOnline, true)
+
+		// Restore aggregated counters and histograms
+		if m.aggs != nil {
+			for _, par := range m.aggs.allParams() {
+				m.Params.Add(par.Name, par.Value)
+			}
+		}
+
+	// Parameter received: update peers parameter
+	case CmdParameter:
+		par := NewParameter()
+		if err = par.UnmarshalBinary(data); err != nil {
+			return
+		}
+		m, ok := p.Get(from)
+		if !ok {
+			err = fmt.Errorf("%w: %s", ErrUnknownPeer, from)
+			return
+		}
+		p.setParam(m, *par)
+		err = p.storeParams(from, *par)
+
+	// Parameters batch received: update peers parameters
+	case CmdParameters:
+		var params []Parameter
+		if params, err = UnmarshalParams(data); err != nil {
+			return
+		}
+		m, ok := p.Get(from)
+		if !ok {
+			err = fmt.Errorf("%w: %s", ErrUnknownPeer, from)
+			return
+		}
+		for _, par := range params {
+			p.setParam(m, par)
+		}
+		err = p.storeParams(from, params...)
+
+	// Counters and histograms deltas received: aggregate it
+	case CmdAggregates:
+		m, ok := p.Get(from)
+		if !ok {
+			err = fmt.Errorf("%w: %s", ErrUnknownPeer, from)
+			return
+		}
+		p.Lock()
+		if m.aggs == nil {
+			m.aggs = newPeerAggregates()
+		}
+		aggs := m.aggs
+		p.Unlock()
+
+		var params []Parameter
+		params, err = aggs.process(data)
+		for _, par := range params {
+			m.Params.Add(par.Name, par.Value)
+		}
+
+	default:
+		err = fmt.Errorf("unknown command: %d", cmd)
+	}
+	return
+}
+
+// SetStore set on-disk store where parameters received by Process are
+// written. Nil store disables writing
+func (p *Peers) SetStore(s *Store) {
+	p.Lock()
+	defer p.Unlock()
+	p.store = s
+}
+
+// storeParams write parameters received from peer to store if it set
+func (p *Peers) storeParams(from string, params ...Parameter) (err error) {
+	p.RLock()
+	s := p.store
+	p.RUnlock()
+	if s == nil {
+		return
+	}
+
+	now := time.Now()
+	for _, par := range params {
+		if e := s.Append(from, par, now); e != nil && err == nil {
+			err = fmt.Errorf("store parameter %s: %w", par.Name, e)
+		}
+	}
+	return
+}
+
+// setParam set peers metric parameter received from monitoring client
+func (p *Peers) setParam(m *Metric, par Parameter) {
+	m.Params.Add(par.Name, par.Value)
+
+	// Peer gracefully going offline
+	if par.Name == ParamGoingOffline && par.Value == true {
+		m.Params.Add(ParamOnline, false)
+	}
+}
+
+// Disconnected set peer parameter online to false. It should be called when
+// teonet peer with address disconnected from monitor. Returns false if peer
+// not found
+func (p *Peers) Disconnected(address string) (ok bool) {
+	m, ok := p.Get(address)
+	if !ok {
+		return
+	}
+	m.Params.Add(ParamOnline, false)
+	return
+}
@@ -0,0 +1,169 @@
+package teomon
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+// fakeTeonet is in-memory TeonetInterface which deliver sent packets to
+// monitor Peers
+type fakeTeonet struct {
+	address   string
+	peers     *Peers
+	numPeers  int
+	connected func()
+	event     func(e byte)
+	sent      map[byte]int
+	sync.Mutex
+}
+
+func (t *fakeTeonet) WhenConnectedDisconnected(f func(e byte)) {
+	t.Lock()
+	defer t.Unlock()
+	t.event = f
+}
+
+func (t *fakeTeonet) WhenConnectedTo(address string, f func()) {
+	t.Lock()
+	defer t.Unlock()
+	t.connected = f
+}
+
+func (t *fakeTeonet) ConnectTo(address string, attr ...interface{}) error {
+	t.Lock()
+	f := t.connected
+	t.Unlock()
+	if f != nil {
+		f()
+	}
+	return nil
+}
+
+func (t *fakeTeonet) SendTo(address string, data []byte, attr ...interface{}) (int, error) {
+	t.Lock()
+	if t.sent == nil {
+		t.sent = make(map[byte]int)
+	}
+	t.sent[data[0]]++
+	t.Unlock()
+	if err := t.peers.Process(t.address, data[0], data[1:]); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+func (t *fakeTeonet) Address() string { return t.address }
+func (t *fakeTeonet) NumPeers() int   { return t.numPeers }
+
+func TestProcess(t *testing.T) {
+
+	peers := NewPeers()
+	teo := &fakeTeonet{address: "client-1", peers: peers, numPeers: 3}
+
+	m := NewMetric()
+	m.AppShort = "test-app"
+	m.AppVersion = "0.0.1"
+	mon := Connect(teo, "monitor", *m)
+
+	// Check peer metric and parameters received on connect
+	metric, ok := peers.Get("client-1")
+	if !ok {
+		t.Error("peer not added")
+		return
+	}
+	if metric.AppShort != "test-app" || !metric.New {
+		t.Error("wrong peer metric", metric.AppShort, metric.New)
+		return
+	}
+	if val, _ := metric.Params.Get(ParamOnline); val != true {
+		t.Error("wrong online parameter", val)
+		return
+	}
+	if val, _ := metric.Params.Get(ParamPeers); val != 3 {
+		t.Error("wrong peers parameter", val)
+		return
+	}
+
+	// Custom parameter
+	mon.SendParam("num_users", 12)
+	if val, _ := metric.Params.Get("num_users"); val != 12 {
+		t.Error("wrong num_users parameter", val)
+		return
+	}
+
+	// Disconnect
+	if !peers.Disconnected("client-1") {
+		t.Error("peer not found on disconnect")
+		return
+	}
+	if val, _ := metric.Params.Get(ParamOnline); val != false {
+		t.Error("wrong online parameter after disconnect", val)
+		return
+	}
+
+	// Parameter from unknown peer
+	par := Parameter{Name: ParamPeers, Value: 1}
+	data, _ := par.MarshalBinary()
+	err := peers.Process("client-2", CmdParameter, data)
+	if !errors.Is(err, ErrUnknownPeer) {
+		t.Error("wrong unknown peer error", err)
+		return
+	}
+}
+
+func TestMonitorClose(t *testing.T) {
+
+	peers := NewPeers()
+	teo := &fakeTeonet{address: "client-1", peers: peers}
+	mon := Connect(teo, "monitor", *NewMetric())
+
+	if err := mon.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+	metric, _ := peers.Get("client-1")
+	if val, _ := metric.Params.Get(ParamOnline); val != false {
+		t.Error("wrong online parameter after close", val)
+		return
+	}
+	if err := mon.SendParam("num_users", 1); !errors.Is(err, ErrMonitorClosed) {
+		t.Error("wrong send after close error", err)
+		return
+	}
+
+	// Callbacks are no-ops after close
+	teo.event(4)
+	if val, _ := metric.Params.Get(ParamPeers); val != 0 {
+		t.Error("peers parameter sent after close", val)
+		return
+	}
+}
+
+func TestSendParamError(t *testing.T) {
+
+	peers := NewPeers()
+	teo := &fakeTeonet{address: "client-1", peers: peers}
+	mon := Connect(teo, "monitor", *NewMetric())
+
+	var handled []error
+	mon.OnError(func(err error) { handled = append(handled, err) })
+
+	// Marshal error
+	if err := mon.SendParam("chan", make(chan int)); err == nil {
+		t.Error("unsupported value sent")
+		return
+	}
+
+	// Send error
+	peers.Del("client-1")
+	if err := mon.SendParam(ParamPeers, 1); !errors.Is(err, ErrUnknownPeer) {
+		t.Error("wrong send error", err)
+		return
+	}
+
+	if len(handled) != 2 {
+		t.Error("wrong number of handled errors", len(handled))
+		return
+	}
+}
@@ -0,0 +1,377 @@
+// Copyright 2021-22 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet v5 monitoring peers snapshot files
+
+package teomon
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Snapshot file format: 4 bytes magic, 1 byte format version, 1 byte
+// compression, payload written by Peers.WriteTo and compressed if compression
+// set, 4 bytes CRC32 (IEEE) of stored payload and 8 bytes stored payload
+// length. Files without magic are loaded as legacy raw snapshots
+const (
+	snapshotMagic   = "TMSN"
+	snapshotVersion = 1
+
+	snapshotHeaderLen  = 6
+	snapshotTrailerLen = 12
+)
+
+// Compression is snapshot payload compression. Only gzip is supported, zstd
+// is not available in the standard library
+type Compression byte
+
+// Snapshot compressions
+const (
+	CompressionNone Compression = iota
+	CompressionGzip
+)
+
+// ErrSnapshotChecksum returned when snapshot payload checksum or length is
+// wrong
+var ErrSnapshotChecksum = errors.New("wrong snapshot checksum")
+
+// SnapshotOptions is Peers.SaveSnapshot options
+type SnapshotOptions struct {
+	Backups     int         // Number of kept previous snapshots
+	Compression Compression // Payload compression
+}
+
+// SaveSnapshot save peers to file in checksummed and optionally compressed
+// snapshot format. The file is written atomically, see Save
+func (p *Peers) SaveSnapshot(file string, opts SnapshotOptions) (err error) {
+
+	// Set all metrics New value to false
+	p.Each(func(m *Metric) {
+		m.New = false
+	})
+
+	return writeFileAtomic(file, opts.Backups, func(w io.Writer) error {
+		return p.writeSnapshot(w, opts.Compression)
+	})
+}
+
+// writeSnapshot write peers to w in snapshot format
+func (p *Peers) writeSnapshot(w io.Writer, compression Compression) (err error) {
+	bw := bufio.NewWriter(w)
+
+	// Header
+	bw.WriteString(snapshotMagic)
+	bw.WriteByte(snapshotVersion)
+	bw.WriteByte(byte(compression))
+
+	// Payload
+	crc := crc32.NewIEEE()
+	cw := &countWriter{w: io.MultiWriter(bw, crc)}
+	switch compression {
+	case CompressionNone:
+		_, err = p.WriteTo(cw)
+	case CompressionGzip:
+		gz := gzip.NewWriter(cw)
+		if _, err = p.WriteTo(gz); err == nil {
+			err = gz.Close()
+		}
+	default:
+		err = fmt.Errorf("unsupported snapshot compression: %d", compression)
+	}
+	if err != nil {
+		return
+	}
+
+	// Trailer
+	binary.Write(bw, binary.LittleEndian, crc.Sum32())
+	binary.Write(bw, binary.LittleEndian, uint64(cw.n))
+
+	return bw.Flush()
+}
+
+// loadSnapshot load peers from snapshot file. Peers are replaced only if
+// snapshot is valid
+func (p *Peers) loadSnapshot(file string) (err error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return
+	}
+
+	// Legacy snapshot without header
+	hdr := make([]byte, snapshotHeaderLen)
+	if _, err = io.ReadFull(f, hdr); err != nil || string(hdr[:4]) != snapshotMagic {
+		if _, err = f.Seek(0, io.SeekStart); err != nil {
+			return
+		}
+		_, err = p.ReadFrom(bufio.NewReader(f))
+		return
+	}
+	if hdr[4] != snapshotVersion {
+		return fmt.Errorf("unsupported snapshot version: %d", hdr[4])
+	}
+	payloadLen := fi.Size() - snapshotHeaderLen - snapshotTrailerLen
+	if payloadLen < 0 {
+		return fmt.Errorf("%w: truncated file", ErrSnapshotChecksum)
+	}
+
+	// Read payload to temporary peers and calculate checksum
+	crc := crc32.NewIEEE()
+	payload := bufio.NewReader(io.TeeReader(io.LimitReader(f, payloadLen), crc))
+	tmp := NewPeers()
+	var errRead error
+	switch Compression(hdr[5]) {
+	case CompressionNone:
+		_, errRead = tmp.ReadFrom(payload)
+	case CompressionGzip:
+		var gz *gzip.Reader
+		if gz, errRead = gzip.NewReader(payload); errRead == nil {
+			_, errRead = tmp.ReadFrom(gz)
+		}
+	default:
+		return fmt.Errorf("unsupported snapshot compression: %d", hdr[5])
+	}
+	io.Copy(io.Discard, payload)
+
+	// Check trailer
+	var sum uint32
+	var l uint64
+	if err = binary.Read(f, binary.LittleEndian, &sum); err != nil {
+		return
+	}
+	if err = binary.Read(f, binary.LittleEndian, &l); err != nil {
+		return
+	}
+	if sum != crc.Sum32() || int64(l) != payloadLen {
+		return ErrSnapshotChecksum
+	}
+	if errRead != nil {
+		return errRead
+	}
+
+	p.replaceMetrics(tmp.metrics)
+	return
+}
+
+// writeFileAtomic write file with write function to temporary file, sync and
+// close it, rotate backups and atomically rename temporary file to file
+func writeFileAtomic(file string, backups int, write func(w io.Writer) error) (err error) {
+	dir := filepath.Dir(file)
+	f, err := os.CreateTemp(dir, filepath.Base(file)+".tmp*")
+	if err != nil {
+		return
+	}
+	tmp := f.Name()
+	defer func() {
+		if err != nil {
+			os.Remove(tmp)
+		}
+	}()
+
+	err = write(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return
+	}
+
+	if err = rotateBackups(file, backups); err != nil {
+		return
+	}
+	if err = os.Rename(tmp, file); err != nil {
+		return
+	}
+	syncDir(dir)
+	return
+}
+
+// backupName return name of backup file number n
+func backupName(file string, n int) string {
+	return fmt.Sprintf("%s.%d", file, n)
+}
+
+// rotateBackups shift backups file.1 ... file.N-1 to file.2 ... file.N and
+// keep current file as file.1. The current file is hard linked when possible
+// so it exists during rotation
+func rotateBackups(file string, backups int) (err error) {
+	if backups <= 0 {
+		return
+	}
+	if _, err = os.Stat(file); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return
+	}
+
+	os.Remove(backupName(file, backups))
+	for i := backups - 1; i >= 1; i-- {
+		err = os.Rename(backupName(file, i), backupName(file, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return
+		}
+	}
+	if err = os.Link(file, backupName(file, 1)); err != nil {
+		err = os.Rename(file, backupName(file, 1))
+	}
+	return
+}
+
+// syncDir sync directory to persist renames. It is best effort as some
+// platforms do not support directory sync
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
+
+// WriteTo write peers to w. Metrics are streamed one by one after versioned
+// format header, each metric is prefixed with uint32 length
+func (p *Peers) WriteTo(w io.Writer) (n int64, err error) {
+	p.RLock()
+	defer p.RUnlock()
+
+	cw := &countWriter{w: w}
+	hdr := new(bytes.Buffer)
+	writeHeader(hdr)
+	if _, err = cw.Write(hdr.Bytes()); err != nil {
+		return cw.n, err
+	}
+
+	for _, m := range p.metrics {
+		var data []byte
+		if data, err = m.MarshalBinary(); err != nil {
+			return cw.n, err
+		}
+		if err = writeLen(cw, len(data)); err != nil {
+			return cw.n, err
+		}
+		if _, err = cw.Write(data); err != nil {
+			return cw.n, err
+		}
+	}
+	return cw.n, nil
+}
+
+// ReadFrom read peers from r until EOF. It accepts format written by WriteTo
+// and legacy format written by MarshalBinary. Peers are replaced only if all
+// metrics was read successfully
+func (p *Peers) ReadFrom(r io.Reader) (n int64, err error) {
+	cr := &countReader{r: r}
+	br := bufio.NewReader(cr)
+
+	var metrics []*Metric
+	hdr, err := br.Peek(3)
+	if err == nil && binary.LittleEndian.Uint16(hdr) == wireMagic {
+		br.Discard(3)
+		metrics, err = readMetrics(br)
+	} else {
+		metrics, err = readLegacyMetrics(br)
+	}
+	if err != nil {
+		return cr.n, err
+	}
+
+	p.replaceMetrics(metrics)
+	return cr.n, nil
+}
+
+// readMetrics read uint32 length prefixed metrics until EOF
+func readMetrics(r io.Reader) (metrics []*Metric, err error) {
+	for {
+		var l uint32
+		if err = binary.Read(r, binary.LittleEndian, &l); err == io.EOF {
+			return metrics, nil
+		} else if err != nil {
+			return
+		}
+		if l > maxRecordSize {
+			err = fmt.Errorf("wrong metric length: %d", l)
+			return
+		}
+		data := make([]byte, l)
+		if _, err = io.ReadFull(r, data); err != nil {
+			return
+		}
+		m := NewMetric()
+		if err = m.UnmarshalBinary(data); err != nil {
+			return
+		}
+		metrics = append(metrics, m)
+	}
+}
+
+// readLegacyMetrics read metrics in format written by Peers.MarshalBinary:
+// uint16 number of metrics and uint16 length prefixed metrics
+func readLegacyMetrics(r io.Reader) (metrics []*Metric, err error) {
+	var l uint16
+	if err = binary.Read(r, binary.LittleEndian, &l); err != nil {
+		return
+	}
+	for i := 0; i < int(l); i++ {
+		var ml uint16
+		if err = binary.Read(r, binary.LittleEndian, &ml); err != nil {
+			return
+		}
+		data := make([]byte, ml)
+		if _, err = io.ReadFull(r, data); err != nil {
+			return
+		}
+		m := NewMetric()
+		if err = m.UnmarshalBinary(data); err != nil {
+			return
+		}
+		metrics = append(metrics, m)
+	}
+	return
+}
+
+// writeLen write uint32 length
+func writeLen(w io.Writer, l int) error {
+	return binary.Write(w, binary.LittleEndian, uint32(l))
+}
+
+// countWriter count bytes written to w
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(b []byte) (n int, err error) {
+	n, err = c.w.Write(b)
+	c.n += int64(n)
+	return
+}
+
+// countReader count bytes read from r
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countReader) Read(b []byte) (n int, err error) {
+	n, err = c.r.Read(b)
+	c.n += int64(n)
+	return
+}
@@ -0,0 +1,221 @@
+package teomon
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSaveBackups(t *testing.T) {
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "peers.dat")
+
+	peers := NewPeers()
+	for i := 1; i <= 4; i++ {
+		m := NewMetric()
+		m.Address = string(rune('a' + i - 1))
+		peers.Add(m)
+		if err := peers.Save(file, 2); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	// Current snapshot and two backups, no temporary files
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 3 {
+		t.Error("wrong number of files", len(entries))
+		return
+	}
+	for name, expected := range map[string]int{
+		file: 4, backupName(file, 1): 3, backupName(file, 2): 2,
+	} {
+		p := NewPeers()
+		if err := p.Load(name); err != nil {
+			t.Error(err)
+			return
+		}
+		if len(p.metrics) != expected {
+			t.Error("wrong number of peers in", name, len(p.metrics))
+			return
+		}
+	}
+}
+
+func TestLoadLarge(t *testing.T) {
+
+	file := filepath.Join(t.TempDir(), "peers.dat")
+
+	// More than 1 MiB of peers
+	peers := NewPeers()
+	for i := 0; i < 3000; i++ {
+		m := NewMetric()
+		m.Address = fmt.Sprintf("address-%d", i)
+		peers.Add(m)
+		m.Params.Add("data", strings.Repeat("x", 500))
+	}
+	if err := peers.Save(file); err != nil {
+		t.Error(err)
+		return
+	}
+	if fi, _ := os.Stat(file); fi.Size() < 1024*1024 {
+		t.Error("too small snapshot", fi.Size())
+		return
+	}
+
+	p := NewPeers()
+	if err := p.Load(file); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(p.metrics) != 3000 {
+		t.Error("wrong number of peers", len(p.metrics))
+		return
+	}
+
+	// Legacy format written by MarshalBinary
+	peers = NewPeers()
+	m := NewMetric()
+	m.Address = "legacy"
+	peers.Add(m)
+	data, _ := peers.MarshalBinary()
+	os.WriteFile(file, data, 0644)
+	if err := p.Load(file); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, ok := p.Get("legacy"); !ok || len(p.metrics) != 1 {
+		t.Error("legacy snapshot not loaded")
+		return
+	}
+
+	// Truncated snapshot keeps current peers
+	peers.Save(file)
+	data, _ = os.ReadFile(file)
+	os.WriteFile(file, data[:len(data)-1], 0644)
+	if err := p.Load(file); err == nil || len(p.metrics) != 1 {
+		t.Error("truncated snapshot loaded", err)
+		return
+	}
+}
+
+func TestSnapshotChecksum(t *testing.T) {
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "peers.dat")
+
+	peers := NewPeers()
+	for i := 0; i < 100; i++ {
+		m := NewMetric()
+		m.Address = fmt.Sprintf("address-%d", i)
+		peers.Add(m)
+		m.Params.Add("data", strings.Repeat("x", 100))
+	}
+
+	// Compressed snapshot is smaller and loaded
+	if err := peers.Save(file); err != nil {
+		t.Error(err)
+		return
+	}
+	raw, _ := os.Stat(file)
+	err := peers.SaveSnapshot(file, SnapshotOptions{Compression: CompressionGzip})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	gz, _ := os.Stat(file)
+	if gz.Size() >= raw.Size() {
+		t.Error("snapshot not compressed", gz.Size(), raw.Size())
+		return
+	}
+	p := NewPeers()
+	if err := p.Load(file); err != nil || len(p.metrics) != 100 {
+		t.Error("compressed snapshot not loaded", err, len(p.metrics))
+		return
+	}
+
+	// Corrupted snapshot without backups
+	data, _ := os.ReadFile(file)
+	data[len(data)/2] ^= 0xff
+	os.WriteFile(file, data, 0644)
+	p = NewPeers()
+	if err := p.Load(file); !errors.Is(err, ErrSnapshotChecksum) {
+		t.Error("wrong corrupted snapshot error", err)
+		return
+	}
+
+	// Corrupted snapshot falls back to newest valid backup
+	peers.Save(file, 2)
+	m := NewMetric()
+	m.Address = "new"
+	peers.Add(m)
+	peers.Save(file, 2)
+	data, _ = os.ReadFile(file)
+	data[snapshotHeaderLen+1] ^= 0xff
+	os.WriteFile(file, data, 0644)
+	var events int
+	p.Subscribe(func(e Event) { events++ })
+	if err := p.Load(file); err != nil || len(p.metrics) != 100 {
+		t.Error("backup snapshot not loaded", err, len(p.metrics))
+		return
+	}
+
+	// Loaded peers emit events
+	events = 0
+	p.Disconnected("address-0")
+	if events != 2 {
+		t.Error("wrong number of loaded peer events", events)
+		return
+	}
+}
+
+func TestReadFromEvents(t *testing.T) {
+
+	// newPeers return peers with addresses
+	newPeers := func(addresses ...string) *Peers {
+		p := NewPeers()
+		for _, a := range addresses {
+			m := NewMetric()
+			m.Address = a
+			p.Add(m)
+		}
+		return p
+	}
+
+	buf := new(bytes.Buffer)
+	if _, err := newPeers("a", "b").WriteTo(buf); err != nil {
+		t.Error(err)
+		return
+	}
+
+	peers := newPeers("b", "c")
+	c, _ := peers.Get("c")
+	var events []string
+	peers.Subscribe(func(e Event) {
+		events = append(events, e.Type.String()+" "+e.Address)
+	})
+	if _, err := peers.ReadFrom(buf); err != nil {
+		t.Error(err)
+		return
+	}
+	expected := "peer_removed c, peer_added a, peer_updated b"
+	if strings.Join(events, ", ") != expected {
+		t.Error("wrong load events", events)
+		return
+	}
+
+	// Loaded peers emit changes, replaced peers don't
+	events = nil
+	c.Params.Add(ParamOnline, false)
+	peers.Disconnected("a")
+	expected = "param_changed a, peer_offline a"
+	if strings.Join(events, ", ") != expected {
+		t.Error("wrong loaded peer events", events)
+		return
+	}
+}
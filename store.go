@@ -0,0 +1,575 @@
+// Copyright 2021-22 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet v5 monitoring on-disk parameters store
+
+package teomon
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kirill-scherba/bslice"
+)
+
+// DefaultSegmentSize is default store segment file size
+const DefaultSegmentSize = 16 * 1024 * 1024
+
+// Store files extensions and names
+const (
+	segmentExt    = ".seg"    // Segment file extension
+	tmpExt        = ".tmp"    // Temporary file extension
+	compactMarker = "compact" // Compaction commit marker file name
+)
+
+// maxRecordSize is max size of store record, bigger records are treated as
+// corrupted
+const maxRecordSize = 64 * 1024 * 1024
+
+// ErrStoreClosed returned by Store methods after Store closed
+var ErrStoreClosed = errors.New("store closed")
+
+// StoreOptions is parameters store options
+type StoreOptions struct {
+	SegmentSize int64         // Max segment file size, DefaultSegmentSize if 0
+	MaxAge      time.Duration // Remove samples older than MaxAge, 0 - unlimited
+	MaxSize     int64         // Max total segments size, 0 - unlimited
+}
+
+// Store is append-only segment based on-disk store of peers parameters
+// samples. Samples are appended to the current segment file, new segment is
+// started when current segment reaches SegmentSize. The oldest segments are
+// removed by MaxAge and MaxSize retention
+type Store struct {
+	dir      string
+	opts     StoreOptions
+	segments []int64 // Sequence numbers of closed segments
+	seq      int64   // Current segment sequence number
+	cur      *os.File
+	w        *bufio.Writer
+	size     int64
+	closed   bool
+	sync.Mutex
+
+	// files is read locked by Query while it reads segment files and locked
+	// by Compact while it rewrites them
+	files sync.RWMutex
+}
+
+// storeRecord is stored parameter sample
+type storeRecord struct {
+	address string
+	time    time.Time
+	param   Parameter
+}
+
+// OpenStore open or create parameters store in directory dir
+func OpenStore(dir string, opts StoreOptions) (s *Store, err error) {
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = DefaultSegmentSize
+	}
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+
+	s = &Store{dir: dir, opts: opts}
+	if err = s.recover(); err != nil {
+		return nil, err
+	}
+	if s.segments, err = s.listSegments(); err != nil {
+		return nil, err
+	}
+	if l := len(s.segments); l > 0 {
+		s.seq = s.segments[l-1]
+	}
+	if err = s.openSegment(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Append parameter sample received from peer with address at time t
+func (s *Store) Append(address string, p Parameter, t time.Time) (err error) {
+	data, err := p.MarshalBinary()
+	if err != nil {
+		return
+	}
+
+	var b bslice.ByteSlice
+	payload := new(bytes.Buffer)
+	binary.Write(payload, binary.LittleEndian, t.UnixNano())
+	b.WriteSlice(payload, []byte(address))
+	payload.Write(data)
+
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return ErrStoreClosed
+	}
+
+	if err = writeRecord(s.w, payload.Bytes()); err != nil {
+		return
+	}
+	if err = s.w.Flush(); err != nil {
+		return
+	}
+	s.size += int64(8 + payload.Len())
+
+	// Start new segment
+	if s.size >= s.opts.SegmentSize {
+		if err = s.rotate(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Query return peer parameter samples in time range from - to including
+// bounds. Zero from or to means no bound. Segment files are read without
+// store lock, so Query does not block Append
+func (s *Store) Query(address, name string, from, to time.Time) (samples []Sample, err error) {
+	s.Lock()
+	if s.closed {
+		s.Unlock()
+		return nil, ErrStoreClosed
+	}
+	if err = s.w.Flush(); err != nil {
+		s.Unlock()
+		return
+	}
+	segments := append([]int64{}, s.segments...)
+	cur, size := s.seq, s.size
+	s.Unlock()
+
+	f := func(r storeRecord) {
+		if r.address != address || r.param.Name != name {
+			return
+		}
+		if !from.IsZero() && r.time.Before(from) {
+			return
+		}
+		if !to.IsZero() && r.time.After(to) {
+			return
+		}
+		samples = append(samples, Sample{Time: r.time, Value: r.param.Value})
+	}
+
+	// Closed segments are immutable, current segment is read up to size
+	// flushed before unlock
+	s.files.RLock()
+	defer s.files.RUnlock()
+	for _, seq := range segments {
+		if err = s.readSegment(s.segmentPath(seq), -1, f); err != nil {
+			return
+		}
+	}
+	if err = s.readSegment(s.segmentPath(cur), size, f); err != nil {
+		return
+	}
+
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].Time.Before(samples[j].Time)
+	})
+	return
+}
+
+// compactSegment is segment written by Compact
+type compactSegment struct {
+	path string
+	f    *os.File
+	w    *bufio.Writer
+	size int64
+	last time.Time // Newest sample time
+}
+
+// Compact rewrite closed segments and remove samples older than MaxAge.
+// Samples are packed into segments of SegmentSize, modification time of each
+// compacted segment is set to its newest sample time, so MaxAge and MaxSize
+// retention keep removing the oldest segments one by one
+func (s *Store) Compact() (err error) {
+	s.files.Lock()
+	defer s.files.Unlock()
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return ErrStoreClosed
+	}
+	if len(s.segments) == 0 {
+		return
+	}
+
+	// Write samples to temporary files. Number of compacted segments is not
+	// greater than number of closed segments, the last one takes the rest
+	var outs []*compactSegment
+	var out *compactSegment
+	minTime := s.minTime()
+	err = s.each(s.segments, func(r storeRecord) {
+		if r.time.Before(minTime) {
+			return
+		}
+		payload := new(bytes.Buffer)
+		binary.Write(payload, binary.LittleEndian, r.time.UnixNano())
+		bslice.ByteSlice{}.WriteSlice(payload, []byte(r.address))
+		data, e := r.param.MarshalBinary()
+		if e != nil {
+			return
+		}
+		payload.Write(data)
+
+		if err != nil {
+			return
+		}
+		if out == nil || (out.size >= s.opts.SegmentSize && len(outs) < len(s.segments)) {
+			path := s.segmentPath(s.segments[len(outs)]) + tmpExt
+			f, e := os.Create(path)
+			if e != nil {
+				err = e
+				return
+			}
+			out = &compactSegment{path: path, f: f, w: bufio.NewWriter(f)}
+			outs = append(outs, out)
+		}
+		if e = writeRecord(out.w, payload.Bytes()); e != nil && err == nil {
+			err = e
+		}
+		out.size += int64(8 + payload.Len())
+		if r.time.After(out.last) {
+			out.last = r.time
+		}
+	})
+	for _, out := range outs {
+		if e := out.w.Flush(); e != nil && err == nil {
+			err = e
+		}
+		if e := out.f.Sync(); e != nil && err == nil {
+			err = e
+		}
+		if e := out.f.Close(); e != nil && err == nil {
+			err = e
+		}
+		os.Chtimes(out.path, out.last, out.last)
+	}
+	if err != nil {
+		for _, out := range outs {
+			os.Remove(out.path)
+		}
+		return
+	}
+
+	// Commit compaction with marker, so it is finished by OpenStore if
+	// process stops while segments are replaced
+	if err = s.writeCompactMarker(s.segments, len(outs)); err != nil {
+		for _, out := range outs {
+			os.Remove(out.path)
+		}
+		return
+	}
+	if err = s.finishCompact(s.segments, len(outs)); err != nil {
+		return
+	}
+	s.segments = s.segments[:len(outs)]
+	return
+}
+
+// writeCompactMarker atomically write compaction marker which contains
+// number of compacted segments n and sequence numbers of compacted closed
+// segments
+func (s *Store) writeCompactMarker(segments []int64, n int) (err error) {
+	var data strings.Builder
+	fmt.Fprintln(&data, n)
+	for _, seq := range segments {
+		fmt.Fprintln(&data, seq)
+	}
+	path := filepath.Join(s.dir, compactMarker)
+	f, err := os.Create(path + tmpExt)
+	if err != nil {
+		return
+	}
+	_, err = f.WriteString(data.String())
+	if err == nil {
+		err = f.Sync()
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(path+tmpExt, path)
+	}
+	if err != nil {
+		os.Remove(path + tmpExt)
+		return
+	}
+	syncDir(s.dir)
+	return
+}
+
+// finishCompact replace the first n segments with compacted temporary files,
+// remove the rest of segments and compaction marker. It may be executed
+// again after it was interrupted
+func (s *Store) finishCompact(segments []int64, n int) (err error) {
+	for _, seq := range segments[:n] {
+		path := s.segmentPath(seq)
+		if _, e := os.Stat(path + tmpExt); e != nil {
+			continue // Already replaced
+		}
+		if err = os.Rename(path+tmpExt, path); err != nil {
+			return
+		}
+	}
+	for _, seq := range segments[n:] {
+		if e := os.Remove(s.segmentPath(seq)); e != nil && !os.IsNotExist(e) {
+			return e
+		}
+	}
+	syncDir(s.dir)
+	return os.Remove(filepath.Join(s.dir, compactMarker))
+}
+
+// recover finish interrupted compaction and remove temporary files left by
+// not committed one
+func (s *Store) recover() (err error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, compactMarker))
+	switch {
+	case os.IsNotExist(err):
+		err = nil
+	case err != nil:
+		return
+	default:
+		var n int
+		var segments []int64
+		fields := strings.Fields(string(data))
+		if len(fields) > 0 {
+			n, err = strconv.Atoi(fields[0])
+		}
+		for i := 1; err == nil && i < len(fields); i++ {
+			var seq int64
+			seq, err = strconv.ParseInt(fields[i], 10, 64)
+			segments = append(segments, seq)
+		}
+		if err != nil || len(fields) == 0 || n < 0 || n > len(segments) {
+			return fmt.Errorf("wrong compaction marker: %q", data)
+		}
+		if err = s.finishCompact(segments, n); err != nil {
+			return
+		}
+	}
+
+	// Remove temporary files
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), tmpExt) {
+			os.Remove(filepath.Join(s.dir, e.Name()))
+		}
+	}
+	return
+}
+
+// Close store
+func (s *Store) Close() (err error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return ErrStoreClosed
+	}
+	s.closed = true
+	if err = s.w.Flush(); err != nil {
+		s.cur.Close()
+		return
+	}
+	return s.cur.Close()
+}
+
+// rotate close current segment, start new one and apply retention
+func (s *Store) rotate() (err error) {
+	if err = s.cur.Sync(); err != nil {
+		return
+	}
+	if err = s.cur.Close(); err != nil {
+		return
+	}
+	s.segments = append(s.segments, s.seq)
+	if err = s.openSegment(); err != nil {
+		return
+	}
+	s.retention()
+	return
+}
+
+// openSegment open new current segment
+func (s *Store) openSegment() (err error) {
+	s.seq++
+	s.cur, err = os.OpenFile(s.segmentPath(s.seq),
+		os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return
+	}
+	s.w = bufio.NewWriter(s.cur)
+	s.size = 0
+	return
+}
+
+// retention remove the oldest closed segments by MaxAge and MaxSize
+func (s *Store) retention() {
+	var total int64
+	sizes := make([]int64, len(s.segments))
+	times := make([]time.Time, len(s.segments))
+	for i, seq := range s.segments {
+		if fi, err := os.Stat(s.segmentPath(seq)); err == nil {
+			sizes[i], times[i] = fi.Size(), fi.ModTime()
+			total += fi.Size()
+		}
+	}
+
+	minTime := s.minTime()
+	var removed int
+	for i, seq := range s.segments {
+		tooOld := s.opts.MaxAge > 0 && times[i].Before(minTime)
+		tooBig := s.opts.MaxSize > 0 && total+s.size > s.opts.MaxSize
+		if !tooOld && !tooBig {
+			break
+		}
+		os.Remove(s.segmentPath(seq))
+		total -= sizes[i]
+		removed++
+	}
+	s.segments = s.segments[removed:]
+}
+
+// minTime return time of the oldest sample kept by MaxAge retention
+func (s *Store) minTime() time.Time {
+	if s.opts.MaxAge <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(-s.opts.MaxAge)
+}
+
+// each read records of segments and execute f for each record. Reading of
+// segment stops on truncated or corrupted record
+func (s *Store) each(segments []int64, f func(r storeRecord)) (err error) {
+	if err = s.w.Flush(); err != nil {
+		return
+	}
+	for _, seq := range segments {
+		if err = s.readSegment(s.segmentPath(seq), -1, f); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// readSegment read records of segment file. If limit is not negative only
+// first limit bytes of file are read
+func (s *Store) readSegment(path string, limit int64, f func(r storeRecord)) (err error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	var rd io.Reader = file
+	if limit >= 0 {
+		rd = io.LimitReader(file, limit)
+	}
+	r := bufio.NewReader(rd)
+	for {
+		payload, err := readRecord(r)
+		if err != nil {
+			// Truncated or corrupted tail of segment
+			return nil
+		}
+
+		var rec storeRecord
+		buf := bytes.NewBuffer(payload)
+		var ns int64
+		if binary.Read(buf, binary.LittleEndian, &ns) != nil {
+			continue
+		}
+		rec.time = time.Unix(0, ns)
+		if rec.address, err = (bslice.ByteSlice{}).ReadString(buf); err != nil {
+			continue
+		}
+		if rec.param.UnmarshalBinary(buf.Bytes()) != nil {
+			continue
+		}
+		f(rec)
+	}
+}
+
+// listSegments return sorted sequence numbers of segments in store directory
+func (s *Store) listSegments() (segments []int64, err error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		seq, err := strconv.ParseInt(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, seq)
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i] < segments[j] })
+	return
+}
+
+// segmentPath return segment file path by sequence number
+func (s *Store) segmentPath(seq int64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, segmentExt))
+}
+
+// writeRecord write record: uint32 payload length, uint32 payload crc32 and
+// payload
+func writeRecord(w io.Writer, payload []byte) (err error) {
+	var hdr [8]byte
+	binary.LittleEndian.PutUint32(hdr[:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(hdr[4:], crc32.ChecksumIEEE(payload))
+	if _, err = w.Write(hdr[:]); err != nil {
+		return
+	}
+	_, err = w.Write(payload)
+	return
+}
+
+// readRecord read record written by writeRecord
+func readRecord(r io.Reader) (payload []byte, err error) {
+	var hdr [8]byte
+	if _, err = io.ReadFull(r, hdr[:]); err != nil {
+		return
+	}
+	l := binary.LittleEndian.Uint32(hdr[:4])
+	if l > maxRecordSize {
+		err = errors.New("wrong record length")
+		return
+	}
+	payload = make([]byte, l)
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return
+	}
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(hdr[4:]) {
+		err = errors.New("wrong record checksum")
+	}
+	return
+}
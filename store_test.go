@@ -0,0 +1,176 @@
+package teomon
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+
+	dir := t.TempDir()
+	store, err := OpenStore(dir, StoreOptions{SegmentSize: 256})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Write parameters received by monitor
+	peers := NewPeers()
+	peers.SetStore(store)
+	m := NewMetric()
+	data, _ := m.MarshalBinary()
+	peers.Process("a1", CmdMetric, data)
+	for i := 0; i < 20; i++ {
+		data, _ = Parameter{Name: ParamPeers, Value: i}.MarshalBinary()
+		if err = peers.Process("a1", CmdParameter, data); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	if len(store.segments) < 2 {
+		t.Error("segments not rotated", store.segments)
+		return
+	}
+	store.Close()
+
+	// Query after restart
+	store, err = OpenStore(dir, StoreOptions{SegmentSize: 256})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer store.Close()
+	samples, err := store.Query("a1", ParamPeers, time.Time{}, time.Time{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(samples) != 20 || samples[0].Value != 0 || samples[19].Value != 19 {
+		t.Error("wrong samples", len(samples), samples)
+		return
+	}
+
+	// Compaction keeps segments size
+	segments := len(store.segments)
+	if err = store.Compact(); err != nil {
+		t.Error(err)
+		return
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(store.segments) < 2 || len(store.segments) > segments ||
+		len(entries) != len(store.segments)+1 {
+		t.Error("wrong segments after compaction", store.segments, len(entries))
+		return
+	}
+	for _, seq := range store.segments {
+		fi, _ := os.Stat(store.segmentPath(seq))
+		if fi.Size() > 2*store.opts.SegmentSize {
+			t.Error("too big compacted segment", fi.Size())
+			return
+		}
+	}
+	samples, _ = store.Query("a1", ParamPeers, time.Time{}, time.Time{})
+	if len(samples) != 20 {
+		t.Error("wrong samples after compaction", len(samples))
+		return
+	}
+
+	// Retention by size
+	store.opts.MaxSize = 512
+	for i := 0; i < 20; i++ {
+		store.Append("a1", Parameter{Name: "queue", Value: i}, time.Now())
+	}
+	samples, _ = store.Query("a1", "queue", time.Time{}, time.Time{})
+	if len(samples) == 0 || len(samples) == 20 {
+		t.Error("wrong samples after retention", len(samples))
+		return
+	}
+}
+
+func TestStoreQueryConcurrent(t *testing.T) {
+
+	store, err := OpenStore(t.TempDir(), StoreOptions{SegmentSize: 1024})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer store.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 500; i++ {
+			store.Append("a1", Parameter{Name: ParamPeers, Value: i}, time.Now())
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		if _, err := store.Query("a1", ParamPeers, time.Time{}, time.Time{}); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	<-done
+
+	samples, _ := store.Query("a1", ParamPeers, time.Time{}, time.Time{})
+	if len(samples) != 500 {
+		t.Error("wrong number of samples", len(samples))
+		return
+	}
+}
+
+func TestStoreCompactRecover(t *testing.T) {
+
+	dir := t.TempDir()
+	store, err := OpenStore(dir, StoreOptions{SegmentSize: 256})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for i := 0; i < 20; i++ {
+		store.Append("a1", Parameter{Name: ParamPeers, Value: i}, time.Now())
+	}
+	segments := append([]int64{}, store.segments...)
+	if len(segments) < 2 {
+		t.Error("segments not rotated", segments)
+		return
+	}
+	store.Close()
+
+	// Compaction interrupted after the first segment replaced: the first
+	// segment contains all samples, the rest of segments are not removed
+	var all []byte
+	for _, seq := range segments {
+		data, _ := os.ReadFile(store.segmentPath(seq))
+		all = append(all, data...)
+	}
+	os.WriteFile(store.segmentPath(segments[0])+tmpExt, all, 0644)
+	if err = store.writeCompactMarker(segments, 1); err != nil {
+		t.Error(err)
+		return
+	}
+	os.Rename(store.segmentPath(segments[0])+tmpExt, store.segmentPath(segments[0]))
+
+	// Not committed compaction temporary file
+	os.WriteFile(store.segmentPath(segments[1])+tmpExt, all, 0644)
+
+	store, err = OpenStore(dir, StoreOptions{SegmentSize: 256})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer store.Close()
+	samples, _ := store.Query("a1", ParamPeers, time.Time{}, time.Time{})
+	if len(samples) != 20 {
+		t.Error("wrong samples after recover", len(samples))
+		return
+	}
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if filepath.Ext(e.Name()) != segmentExt {
+			t.Error("file not removed", e.Name())
+			return
+		}
+	}
+}
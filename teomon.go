@@ -7,6 +7,7 @@ package teomon
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"encoding/json"
 	"errors"
@@ -23,8 +24,10 @@ import (
 
 // Comand constant
 const (
-	CmdMetric    byte = 130
-	CmdParameter byte = 131
+	CmdMetric     byte = 130
+	CmdParameter  byte = 131
+	CmdParameters byte = 132
+	CmdAggregates byte = 133
 
 	version = "0.5.13"
 )
@@ -39,49 +42,83 @@ type TeonetInterface interface {
 	NumPeers() int
 }
 
-// Connect to monitor peer and send metric
+// Connect to monitor peer and send metric. Connect tries to connect to monitor
+// every second until connected, use ConnectContext to cancel connection or
+// limit number of attempts
 func Connect(teo TeonetInterface, address string, m Metric, t ...TeonetInterface) (mon *Monitor) {
+	opts := []ConnectOption{WithBackoff(time.Second, time.Second, 1), WithJitter(0)}
+	if len(t) > 0 {
+		opts = append(opts, WithTeonetCheck(t[0]))
+	}
+	mon, _ = ConnectContext(context.Background(), teo, address, m, opts...)
+	return
+}
+
+// ConnectContext connect to monitor peer and send metric. It retries
+// connection with exponential backoff until connected, context canceled or
+// maximum number of attempts reached
+func ConnectContext(ctx context.Context, teo TeonetInterface, address string,
+	m Metric, opts ...ConnectOption) (mon *Monitor, err error) {
+
+	o := newConnectOptions(teo, opts...)
 
 	mon = new(Monitor)
 	mon.teo = teo
 	mon.address = address
-
-	// Which teonet check for connected: the same or from t parameter
-	var teocheck = teo
-	if len(t) > 0 {
-		teocheck = t[0]
+	mon.done = make(chan struct{})
+	mon.flushWindow = o.flushWindow
+	mon.aggInterval = o.aggInterval
+	mon.start = m.AppStartTime
+	if mon.start.IsZero() {
+		mon.start = time.Now()
 	}
 
+	// Which teonet check for connected: the same or from options
+	var teocheck = o.teocheck
+
 	// When connected to monitor
 	teo.WhenConnectedTo(address, func() {
+		if mon.isClosed() {
+			return
+		}
 		m.NewParams()
 
 		// Send metric
-		data, _ := m.MarshalBinary()
-		data = append([]byte{CmdMetric}, data...)
-		teo.SendTo(address, data)
+		data, err := m.MarshalBinary()
+		if err != nil {
+			mon.error(fmt.Errorf("marshal metric: %w", err))
+			return
+		}
+		mon.send(CmdMetric, data)
 
 		// Send parameter 'number of peers'
-		mon.SendParam(ParamPeers, teocheck.NumPeers())
+		params := map[string]interface{}{ParamPeers: teocheck.NumPeers()}
 
 		// Send parameter 'host name'
 		if h, err := os.Hostname(); err == nil {
-			mon.SendParam(ParamHost, h)
+			params[ParamHost] = h
 		}
 
 		// Send parameter 'machineid'
 		if id, err := getMachineID(); err == nil {
-			mon.SendParam(ParamMachineID, id)
+			params[ParamMachineID] = id
 		}
+
+		mon.SendParams(params)
 	})
 
 	// Connect to monitor
-	for teo.ConnectTo(address) != nil {
-		time.Sleep(1 * time.Second)
+	if err = o.connect(ctx, teo, address); err != nil {
+		mon.stopTickers()
+		mon.setClosed()
+		return
 	}
 
 	// Process connected/disconnected events and send Parameter "peers" to monitor
 	teocheck.WhenConnectedDisconnected(func(e byte) {
+		if mon.isClosed() {
+			return
+		}
 		numPeers := teocheck.NumPeers()
 		if e == 5 /* EventDisconnected */ {
 			numPeers--
@@ -92,20 +129,127 @@ func Connect(teo TeonetInterface, address string, m Metric, t ...TeonetInterface
 	return
 }
 
+// ErrMonitorClosed returned by Monitor methods after Monitor closed
+var ErrMonitorClosed = errors.New("monitor closed")
+
 // Teonet monitor struct
 type Monitor struct {
 	teo     TeonetInterface
 	address string
+	start   time.Time
+	closed  bool
+	onError func(err error)
+	done    chan struct{}
+	stop    sync.Once
+	wg      sync.WaitGroup
+	mu      sync.RWMutex
+
+	flushWindow time.Duration
+	pending     map[string]interface{}
+	flushTimer  *time.Timer
+	pendingMu   sync.Mutex
+
+	aggs        *aggregates
+	aggOnce     sync.Once
+	aggInterval time.Duration
+}
+
+// OnError set error handler. The handler is called for every monitor data
+// which was not sent to monitor: marshal or teonet send error
+func (mon *Monitor) OnError(f func(err error)) {
+	mon.mu.Lock()
+	defer mon.mu.Unlock()
+	mon.onError = f
+}
+
+// error call error handler if it set
+func (mon *Monitor) error(err error) {
+	mon.mu.RLock()
+	f := mon.onError
+	mon.mu.RUnlock()
+	if f != nil {
+		f(err)
+	}
 }
 
-// SendParam send parameter to monitor
-func (mon Monitor) SendParam(name string, value interface{}) {
+// send command with data to monitor
+func (mon *Monitor) send(cmd byte, data []byte) (err error) {
+	data = append([]byte{cmd}, data...)
+	if _, err = mon.teo.SendTo(mon.address, data); err != nil {
+		err = fmt.Errorf("send to monitor %s: %w", mon.address, err)
+		mon.error(err)
+	}
+	return
+}
+
+// Close monitor. It stops registered gauges, sends queued parameters and
+// counters, sends parameter 'goingoffline' to
+// monitor so it can distinguish graceful shutdown from crash, and makes teonet
+// callbacks registered in Connect no-ops. SendParam returns ErrMonitorClosed after Close
+func (mon *Monitor) Close() (err error) {
+	if mon.isClosed() {
+		return ErrMonitorClosed
+	}
+	mon.stopTickers()
+	mon.pendingMu.Lock()
+	mon.setClosed()
+	mon.pendingMu.Unlock()
+	mon.flush()
+	mon.sendAggregates()
+	err = mon.sendParam(ParamGoingOffline, true)
+	return
+}
+
+// stopTickers stop all monitor goroutines and wait they finished
+func (mon *Monitor) stopTickers() {
+	mon.mu.Lock()
+	mon.stop.Do(func() { close(mon.done) })
+	mon.mu.Unlock()
+	mon.wg.Wait()
+}
+
+// isClosed return true if monitor closed
+func (mon *Monitor) isClosed() bool {
+	mon.mu.RLock()
+	defer mon.mu.RUnlock()
+	return mon.closed
+}
+
+// setClosed set monitor closed
+func (mon *Monitor) setClosed() {
+	mon.mu.Lock()
+	defer mon.mu.Unlock()
+	mon.closed = true
+}
+
+// SendParam send parameter to monitor. It returns marshal or teonet send
+// error, the same error is passed to error handler set by OnError. If flush
+// window set with WithFlushWindow option the parameter is queued and sent
+// together with other parameters queued during the window, teonet send error
+// is passed to error handler only in this case
+func (mon *Monitor) SendParam(name string, value interface{}) (err error) {
+	if mon.isClosed() {
+		return ErrMonitorClosed
+	}
+	if mon.flushWindow > 0 {
+		return mon.queue(name, value)
+	}
+	return mon.sendParam(name, value)
+}
+
+// sendParam send parameter to monitor
+func (mon *Monitor) sendParam(name string, value interface{}) (err error) {
 	p := NewParameter()
 	p.Name = name
 	p.Value = value
-	data, _ := p.MarshalBinary()
-	data = append([]byte{CmdParameter}, data...)
-	mon.teo.SendTo(mon.address, data)
+	data, err := p.MarshalBinary()
+	if err != nil {
+		err = fmt.Errorf("marshal parameter %s: %w", name, err)
+		mon.error(err)
+		return
+	}
+	err = mon.send(CmdParameter, data)
+	return
 }
 
 // Metric contain metric struct and methods receiver
@@ -118,6 +262,7 @@ type Metric struct {
 	AppStartTime time.Time
 	New          bool
 	Params       *Parameters
+	aggs         *peerAggregates
 	bslice.ByteSlice
 }
 
@@ -128,6 +273,8 @@ const (
 	ParamHost      = "host"
 	ParamMachineID = "machineid"
 	MayOffline     = "mayoffline"
+
+	ParamGoingOffline = "goingoffline"
 )
 
 // NewMetric create new metric object
@@ -140,44 +287,103 @@ func NewMetric() (m *Metric) {
 // MarshalBinary binary marshal Metric struct
 func (m Metric) MarshalBinary() (data []byte, err error) {
 	buf := new(bytes.Buffer)
+	writeHeader(buf)
 
-	m.WriteSlice(buf, []byte(m.Address))
-	m.WriteSlice(buf, []byte(m.AppName))
-	m.WriteSlice(buf, []byte(m.AppShort))
-	m.WriteSlice(buf, []byte(m.AppVersion))
-	m.WriteSlice(buf, []byte(m.TeoVersion))
+	writeField(buf, metricAddress, []byte(m.Address))
+	writeField(buf, metricAppName, []byte(m.AppName))
+	writeField(buf, metricAppShort, []byte(m.AppShort))
+	writeField(buf, metricAppVersion, []byte(m.AppVersion))
+	writeField(buf, metricTeoVersion, []byte(m.TeoVersion))
 	//
 	d, err := m.AppStartTime.MarshalBinary()
 	if err != nil {
 		return
 	}
-	m.WriteSlice(buf, d)
+	writeField(buf, metricAppStartTime, d)
 	//
-	binary.Write(buf, binary.LittleEndian, m.New)
+	var isNew byte
+	if m.New {
+		isNew = 1
+	}
+	writeField(buf, metricNew, []byte{isNew})
 
 	m.Params.RLock()
 	defer m.Params.RUnlock()
 
-	if err = binary.Write(buf, binary.LittleEndian, uint16(len(m.Params.m))); err != nil {
-		return
-	}
 	for name, val := range m.Params.m {
 		p := Parameter{Name: name, Value: val}
 		data, err := p.MarshalBinary()
 		if err != nil {
 			return nil, err
 		}
-		m.WriteSlice(buf, data)
+		writeField(buf, metricParam, data)
+	}
+
+	// Monitor side counters and histograms
+	if m.aggs != nil {
+		if d, err = m.aggs.MarshalBinary(); err != nil {
+			return
+		}
+		writeField(buf, metricAggregates, d)
 	}
 
 	data = buf.Bytes()
 	return
 }
 
-// UnmarshalBinary binary unmarshal Metric struct
+// UnmarshalBinary binary unmarshal Metric struct. It accepts versioned and
+// legacy unversioned metric format
 func (m *Metric) UnmarshalBinary(data []byte) (err error) {
 	buf := bytes.NewBuffer(data)
 
+	if !readHeader(buf) {
+		return m.unmarshalLegacy(buf)
+	}
+
+	for buf.Len() > 0 {
+		var tag byte
+		var d []byte
+		if tag, d, err = readField(buf); err != nil {
+			return
+		}
+		switch tag {
+		case metricAddress:
+			m.Address = string(d)
+		case metricAppName:
+			m.AppName = string(d)
+		case metricAppShort:
+			m.AppShort = string(d)
+		case metricAppVersion:
+			m.AppVersion = string(d)
+		case metricTeoVersion:
+			m.TeoVersion = string(d)
+		case metricAppStartTime:
+			if err = m.AppStartTime.UnmarshalBinary(d); err != nil {
+				return
+			}
+		case metricNew:
+			m.New = len(d) > 0 && d[0] != 0
+		case metricParam:
+			p := Parameter{}
+			if p.UnmarshalBinary(d) != nil {
+				continue
+			}
+			m.Params.Add(p.Name, p.Value)
+		case metricAggregates:
+			a := newPeerAggregates()
+			if _, e := a.process(d); e != nil {
+				continue
+			}
+			m.aggs = a
+		}
+	}
+
+	return
+}
+
+// unmarshalLegacy binary unmarshal Metric struct in unversioned format
+func (m *Metric) unmarshalLegacy(buf *bytes.Buffer) (err error) {
+
 	if m.Address, err = m.ReadString(buf); err != nil {
 		return
 	}
@@ -216,7 +422,9 @@ func (m *Metric) UnmarshalBinary(data []byte) (err error) {
 		if err != nil {
 			return err
 		}
-		p.UnmarshalBinary(data)
+		if p.UnmarshalBinary(data) != nil {
+			continue
+		}
 		m.Params.Add(p.Name, p.Value)
 	}
 
@@ -225,7 +433,8 @@ func (m *Metric) UnmarshalBinary(data []byte) (err error) {
 
 // Parameters is metric parameters struct and methods receiver
 type Parameters struct {
-	m map[string]interface{}
+	m      map[string]interface{}
+	notify func(name string, old, val interface{})
 	sync.RWMutex
 }
 
@@ -237,8 +446,21 @@ func (m *Metric) NewParams() {
 // add or update parameter
 func (p *Parameters) Add(name string, val interface{}) {
 	p.Lock()
-	defer p.Unlock()
+	old := p.m[name]
 	p.m[name] = val
+	notify := p.notify
+	p.Unlock()
+
+	if notify != nil {
+		notify(name, old, val)
+	}
+}
+
+// setNotify set parameters changes callback
+func (p *Parameters) setNotify(f func(name string, old, val interface{})) {
+	p.Lock()
+	defer p.Unlock()
+	p.notify = f
 }
 
 // get parameter
@@ -258,6 +480,23 @@ func (p *Parameters) Each(f func(name string, value interface{})) {
 	}
 }
 
+// MarshalJSON json marshal Parameters. Values which can't be encoded to
+// json, like NaN and Inf floats, are written as strings made with fmt.Sprint
+func (p *Parameters) MarshalJSON() ([]byte, error) {
+	p.RLock()
+	defer p.RUnlock()
+
+	m := make(map[string]json.RawMessage, len(p.m))
+	for name, val := range p.m {
+		data, err := json.Marshal(val)
+		if err != nil {
+			data, _ = json.Marshal(fmt.Sprint(val))
+		}
+		m[name] = data
+	}
+	return json.Marshal(m)
+}
+
 // Parameter struct and methods receiver
 type Parameter struct {
 	Name  string
@@ -275,97 +514,84 @@ func NewParameter() (p *Parameter) {
 func (p Parameter) MarshalBinary() (data []byte, err error) {
 	buf := new(bytes.Buffer)
 
-	p.WriteSlice(buf, []byte(p.Name))
-	t := reflect.TypeOf(p.Value).String()
-	p.WriteSlice(buf, []byte(t))
-	switch t {
-	case "string":
-		p.WriteSlice(buf, []byte(p.Value.(string)))
-	case "[]uint8":
-		p.WriteSlice(buf, p.Value.([]byte))
-	case "int":
-		binary.Write(buf, binary.LittleEndian, int32(p.Value.(int)))
-	default:
-		binary.Write(buf, binary.LittleEndian, p.Value)
+	t, value, err := encodeValue(p.Value)
+	if err != nil {
+		return
 	}
 
+	writeHeader(buf)
+	writeField(buf, paramName, []byte(p.Name))
+	writeField(buf, paramType, []byte(t))
+	writeField(buf, paramValue, value)
+
 	data = buf.Bytes()
 	return
 }
 
-// UnmarshalBinary binary unmarshal Parameter struct
+// UnmarshalBinary binary unmarshal Parameter struct. It accepts versioned and
+// legacy unversioned parameter format
 func (p *Parameter) UnmarshalBinary(data []byte) (err error) {
 	buf := bytes.NewBuffer(data)
 
-	if p.Name, err = p.ReadString(buf); err != nil {
-		return
+	if !readHeader(buf) {
+		return p.unmarshalLegacy(buf)
 	}
-	var t string
-	if t, err = p.ReadString(buf); err != nil {
-		return
-	}
-	// fmt.Println("type:", t)
-
-	switch t {
-	case "bool":
-		var val bool
-		if err = binary.Read(buf, binary.LittleEndian, &val); err != nil {
-			return
-		}
-		p.Value = val
 
-	case "int":
-		var val int32
-		if err = binary.Read(buf, binary.LittleEndian, &val); err != nil {
+	var t string
+	var value []byte
+	for buf.Len() > 0 {
+		var tag byte
+		var d []byte
+		if tag, d, err = readField(buf); err != nil {
 			return
 		}
-		p.Value = int(val)
-
-	case "int32":
-		var val int32
-		if err = binary.Read(buf, binary.LittleEndian, &val); err != nil {
-			return
+		switch tag {
+		case paramName:
+			p.Name = string(d)
+		case paramType:
+			t = string(d)
+		case paramValue:
+			value = d
 		}
-		p.Value = val
+	}
 
-	case "uint32":
-		var val uint32
-		if err = binary.Read(buf, binary.LittleEndian, &val); err != nil {
-			return
-		}
-		p.Value = val
+	p.Value, err = decodeValue(t, value)
+	return
+}
 
-	case "float64":
-		var val float64
-		if err = binary.Read(buf, binary.LittleEndian, &val); err != nil {
-			return
-		}
-		p.Value = val
+// unmarshalLegacy binary unmarshal Parameter struct in unversioned format
+func (p *Parameter) unmarshalLegacy(buf *bytes.Buffer) (err error) {
 
-	case "string":
-		var val string
-		if val, err = p.ReadString(buf); err != nil {
-			return
-		}
-		p.Value = val
+	if p.Name, err = p.ReadString(buf); err != nil {
+		return
+	}
+	var t string
+	if t, err = p.ReadString(buf); err != nil {
+		return
+	}
 
-	case "[]uint8":
-		var val []byte
-		if val, err = p.ReadSlice(buf); err != nil {
+	// Strings and byte slices are length prefixed, other values take the rest
+	// of data
+	var value []byte
+	switch t {
+	case "string", "[]uint8":
+		if value, err = p.ReadSlice(buf); err != nil {
 			return
 		}
-		p.Value = val
-
 	default:
-		err = fmt.Errorf("unmarshal error - unsupported type: %s", t)
+		value = buf.Bytes()
 	}
 
+	p.Value, err = decodeValue(t, value)
 	return
 }
 
 // Peers struct and methods receiver
 type Peers struct {
 	metrics []*Metric
+	events  *eventHub
+	history *history
+	store   *Store
 	*sync.RWMutex
 }
 
@@ -373,6 +599,7 @@ type Peers struct {
 func NewPeers() (p *Peers) {
 	p = new(Peers)
 	p.RWMutex = new(sync.RWMutex)
+	p.events = newEventHub()
 	return
 }
 
@@ -392,13 +619,11 @@ func (p *Peers) MarshalBinary() (data []byte, err error) {
 	return
 }
 
-// UnmarshalBinary binary unmarshal Peers struct
+// UnmarshalBinary binary unmarshal Peers struct. Peers are replaced only if
+// all metrics was unmarshalled successfully
 func (p *Peers) UnmarshalBinary(data []byte) (err error) {
-	p.Lock()
-	defer p.Unlock()
-
 	buf := bytes.NewBuffer(data)
-	p.metrics = nil
+	var metrics []*Metric
 	var l uint16
 	if err = binary.Read(buf, binary.LittleEndian, &l); err != nil {
 		return
@@ -414,63 +639,43 @@ func (p *Peers) UnmarshalBinary(data []byte) (err error) {
 		if err != nil {
 			return
 		}
-		p.metrics = append(p.metrics, m)
+		metrics = append(metrics, m)
 	}
+	p.replaceMetrics(metrics)
 	return
 }
 
-// Save peers to file
-func (p *Peers) Save(file string) (err error) {
-
-	f, err := os.Create(file)
-	if err != nil {
-		return
-	}
-
-	// Set all metrics New value to false
-	p.Each(func(m *Metric) {
-		m.New = false
-	})
-
-	data, err := p.MarshalBinary()
-	if err != nil {
-		return
-	}
-
-	_, err = f.Write(data)
-
-	return
+// Save peers to file. Peers are written to temporary file in the same
+// directory which is synced and atomically renamed to file, so the file
+// always contains complete snapshot. If backups is set, up to backups previous
+// snapshots are kept in files file.1 (newest) ... file.N. Use SaveSnapshot to
+// save compressed snapshot
+func (p *Peers) Save(file string, backups ...int) (err error) {
+	var opts SnapshotOptions
+	if len(backups) > 0 {
+		opts.Backups = backups[0]
+	}
+	return p.SaveSnapshot(file, opts)
 }
 
-// Load peers from file
+// Load peers from file. Snapshot checksum is verified, if file is missing or
+// corrupted the newest valid backup file.1 ... file.N is loaded. The error of
+// file is returned if there is no valid backup
 func (p *Peers) Load(file string) (err error) {
-
-	const bufferSize = 1024 * 1024
-
-	// Open file
-	f, err := os.Open(file)
-	if err != nil {
-		return
-	}
-
-	// Read file data
-	data := make([]byte, bufferSize)
-	n, err := f.Read(data)
-	if err != nil {
-		return
-	}
-	if n == bufferSize {
-		err = errors.New("too small read buffer")
+	if err = p.loadSnapshot(file); err == nil {
 		return
 	}
 
-	// Unmarshal config data
-	err = p.UnmarshalBinary(data[:n])
-	if err != nil {
-		return
+	// Fall back to backups
+	for i := 1; ; i++ {
+		name := backupName(file, i)
+		if _, e := os.Stat(name); e != nil {
+			return
+		}
+		if p.loadSnapshot(name) == nil {
+			return nil
+		}
 	}
-
-	return
 }
 
 // find metric by address
@@ -495,9 +700,12 @@ func (p *Peers) Add(metric *Metric) {
 	// Update if exists
 	if _, i, ok := p.find(metric.Address); ok {
 		p.Lock()
-		defer p.Unlock()
-
+		p.metrics[i].Params.setNotify(nil)
 		p.metrics[i] = metric
+		p.Unlock()
+
+		metric.Params.setNotify(p.paramsNotify(metric.Address))
+		p.emit(Event{Type: PeerUpdated, Address: metric.Address})
 		return
 	}
 
@@ -506,8 +714,42 @@ func (p *Peers) Add(metric *Metric) {
 	metric.Params.Add(ParamOnline, true)
 
 	p.Lock()
-	defer p.Unlock()
 	p.metrics = append(p.metrics, metric)
+	p.Unlock()
+
+	metric.Params.setNotify(p.paramsNotify(metric.Address))
+	p.emit(Event{Type: PeerAdded, Address: metric.Address})
+}
+
+// replaceMetrics replace all peers metrics with metrics. Parameters changes
+// callbacks of removed metrics are cleared and set for new metrics, peer
+// added, updated and removed events are emitted
+func (p *Peers) replaceMetrics(metrics []*Metric) {
+	p.Lock()
+	old := p.metrics
+	p.metrics = metrics
+	p.Unlock()
+
+	addresses := make(map[string]bool, len(metrics))
+	for _, m := range metrics {
+		addresses[m.Address] = true
+	}
+	exists := make(map[string]bool, len(old))
+	for _, m := range old {
+		m.Params.setNotify(nil)
+		exists[m.Address] = true
+		if !addresses[m.Address] {
+			p.emit(Event{Type: PeerRemoved, Address: m.Address})
+		}
+	}
+	for _, m := range metrics {
+		m.Params.setNotify(p.paramsNotify(m.Address))
+		if exists[m.Address] {
+			p.emit(Event{Type: PeerUpdated, Address: m.Address})
+		} else {
+			p.emit(Event{Type: PeerAdded, Address: m.Address})
+		}
+	}
 }
 
 // Get peer metric by address
@@ -519,7 +761,13 @@ func (p *Peers) Get(address string) (m *Metric, ok bool) {
 // Del peer by address
 func (p *Peers) Del(address string) (m *Metric, ok bool) {
 	p.Lock()
-	defer p.Unlock()
+	defer func() {
+		p.Unlock()
+		if ok {
+			m.Params.setNotify(nil)
+			p.emit(Event{Type: PeerRemoved, Address: address})
+		}
+	}()
 
 	m, idx, ok := p.find(address, true)
 	if !ok {
@@ -642,9 +890,24 @@ func (p Peers) String() (str string) {
 			l.start, start,
 		)
 		var numParams = 0
+		if rt := runtimeString(m.Params); rt != "" {
+			str += fmt.Sprintf("   %s\n", rt)
+			numParams++
+		}
+		if host := hostString(m.Params); host != "" {
+			str += fmt.Sprintf("   %s\n", host)
+			numParams++
+		}
 		m.Params.Each(func(name string, value interface{}) {
 			switch name {
-			case ParamOnline, ParamPeers, ParamHost, ParamMachineID, MayOffline:
+			case ParamOnline, ParamPeers, ParamHost, ParamMachineID, MayOffline,
+				ParamGoingOffline:
+				return
+			case ParamGoroutines, ParamHeapAlloc, ParamHeapSys, ParamNumGC,
+				ParamGCPause, ParamUptime:
+				return
+			case ParamLoad1, ParamLoad5, ParamLoad15, ParamMemTotal,
+				ParamMemAvailable, ParamCPUUsage, ParamProcRSS, ParamProcFDs:
 				return
 			}
 			str += fmt.Sprintf("   %s: %v\n", name, value)
@@ -667,35 +930,40 @@ func (p Peers) Json() (data []byte, err error) {
 	// Sort metrics
 	p.sortMetrics(p.metrics)
 
-	type Pmetric struct {
-		Metric
-		Online     interface{}
-		Peers      interface{}
-		Host       interface{}
-		MachineID  interface{}
-		MayOffline interface{}
-	}
-
 	var pmetrics []Pmetric
 
 	// Add common parameters to output json
 	for _, m := range p.metrics {
-		mayoffline, _ := m.Params.Get(MayOffline)
-		online, _ := m.Params.Get(ParamOnline)
-		peers, _ := m.Params.Get(ParamPeers)
-		host, _ := m.Params.Get(ParamHost)
-		id, _ := m.Params.Get(ParamMachineID)
-		pm := Pmetric{
-			Metric:     *m,
-			MayOffline: mayoffline,
-			Online:     online,
-			Peers:      peers,
-			Host:       host,
-			MachineID:  id,
-		}
-		pmetrics = append(pmetrics, pm)
+		pmetrics = append(pmetrics, newPmetric(m))
 	}
 
 	// Marshal json
 	return json.Marshal(pmetrics)
 }
+
+// Pmetric is Metric with common parameters used in json output
+type Pmetric struct {
+	Metric
+	Online     interface{}
+	Peers      interface{}
+	Host       interface{}
+	MachineID  interface{}
+	MayOffline interface{}
+}
+
+// newPmetric create Pmetric from Metric
+func newPmetric(m *Metric) Pmetric {
+	mayoffline, _ := m.Params.Get(MayOffline)
+	online, _ := m.Params.Get(ParamOnline)
+	peers, _ := m.Params.Get(ParamPeers)
+	host, _ := m.Params.Get(ParamHost)
+	id, _ := m.Params.Get(ParamMachineID)
+	return Pmetric{
+		Metric:     *m,
+		MayOffline: mayoffline,
+		Online:     online,
+		Peers:      peers,
+		Host:       host,
+		MachineID:  id,
+	}
+}
@@ -1,7 +1,9 @@
 package teomon
 
 import (
+	"encoding/json"
 	"fmt"
+	"math"
 	"testing"
 )
 
@@ -136,24 +138,45 @@ func TestParameter(t *testing.T) {
 	fmt.Println("UnmarshalBinary:", par)
 
 	// Value type unknown
-	par = Parameter{Name: "online", Value: struct{}{}}
+	par = Parameter{Name: "online", Value: make(chan int)}
 
 	data, err = par.MarshalBinary()
-	if err != nil {
+	if err == nil {
+		err = fmt.Errorf("successfully marshal not supported type %T", par.Value)
 		t.Error(err)
 		return
 	}
-	fmt.Println("MarshalBinary:", data)
+	fmt.Println("MarshalBinary:", err)
 
-	par = Parameter{}
-	err = par.UnmarshalBinary(data)
-	if err == nil {
-		err = fmt.Errorf("successfully unmarshal not supported type %T", par.Value)
-		t.Error(err)
-		return
+}
+
+func TestParameterNumeric(t *testing.T) {
+
+	values := []interface{}{
+		int(-1 << 40), uint(1 << 40),
+		int8(-8), int16(-16), int32(-32), int64(-1 << 50),
+		uint8(8), uint16(16), uint32(32), uint64(1 << 60),
+		float32(3.25), float64(-3.14),
 	}
-	fmt.Println("UnmarshalBinary:", err, par)
 
+	for _, val := range values {
+		par := Parameter{Name: "value", Value: val}
+		data, err := par.MarshalBinary()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		par = Parameter{}
+		if err = par.UnmarshalBinary(data); err != nil {
+			t.Error(err)
+			return
+		}
+		if par.Value != val {
+			t.Errorf("wrong unmarshal %T value: %v != %v", val, par.Value, val)
+			return
+		}
+	}
 }
 
 func TestMetric(t *testing.T) {
@@ -317,3 +340,41 @@ func TestSortMetric(t *testing.T) {
 		}
 	}
 }
+
+func TestParametersJSON(t *testing.T) {
+
+	peers := NewPeers()
+	m := NewMetric()
+	m.Address = "a1"
+	peers.Add(m)
+	m.Params.Add("nan", math.NaN())
+	m.Params.Add("inf", math.Inf(1))
+	m.Params.Add("ninf", float32(math.Inf(-1)))
+	m.Params.Add("values", []float64{1, math.NaN()})
+	m.Params.Add("num_users", 10)
+
+	data, err := m.Params.MarshalJSON()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var params map[string]interface{}
+	if err = json.Unmarshal(data, &params); err != nil {
+		t.Error(err)
+		return
+	}
+	for name, expected := range map[string]interface{}{
+		"nan": "NaN", "inf": "+Inf", "ninf": "-Inf", "values": "[1 NaN]",
+		"num_users": 10.0, ParamOnline: true,
+	} {
+		if params[name] != expected {
+			t.Error("wrong json parameter", name, params[name])
+			return
+		}
+	}
+
+	if _, err = peers.Json(); err != nil {
+		t.Error("peers json with non-finite values failed", err)
+		return
+	}
+}
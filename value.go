@@ -0,0 +1,259 @@
+// Copyright 2021-22 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet v5 monitoring parameter values encoding
+
+package teomon
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/kirill-scherba/bslice"
+)
+
+// ErrUnsupportedType returned when parameter value type can't be marshalled
+var ErrUnsupportedType = errors.New("unsupported type")
+
+// checkStringSlice return error if string slice can't be encoded: it has more
+// than 65535 strings or string longer than 65535 bytes
+func checkStringSlice(val []string) error {
+	if len(val) > math.MaxUint16 {
+		return fmt.Errorf("marshal error - %w: []string with %d elements",
+			ErrUnsupportedType, len(val))
+	}
+	for _, s := range val {
+		if len(s) > math.MaxUint16 {
+			return fmt.Errorf("marshal error - %w: []string element of %d bytes",
+				ErrUnsupportedType, len(s))
+		}
+	}
+	return nil
+}
+
+// Type name prefixes of values encoded with generic fallback encoders
+const (
+	binaryPrefix = "binary:"
+	jsonPrefix   = "json:"
+)
+
+// RawValue is parameter value of type which implements
+// encoding.BinaryMarshaler. Decoder does not know such types so it returns
+// type name and binary data. RawValue encoded the same way as original value
+type RawValue struct {
+	Type string
+	Data []byte
+}
+
+// MarshalBinary return raw value data
+func (r RawValue) MarshalBinary() ([]byte, error) { return r.Data, nil }
+
+// encodeValue return type name and binary representation of parameter value
+func encodeValue(v interface{}) (t string, data []byte, err error) {
+	if v == nil {
+		err = errors.New("marshal error - nil value")
+		return
+	}
+
+	t = reflect.TypeOf(v).String()
+	if c, ok := getValueCodec(t); ok {
+		data, err = c.enc(v)
+		return
+	}
+
+	switch val := v.(type) {
+	case string:
+		data = []byte(val)
+	case []byte:
+		data = val
+	case int:
+		data = make([]byte, 8)
+		binary.LittleEndian.PutUint64(data, uint64(int64(val)))
+	case uint:
+		data = make([]byte, 8)
+		binary.LittleEndian.PutUint64(data, uint64(val))
+	case bool, int8, int16, int32, int64, uint8, uint16, uint32, uint64,
+		float32, float64, []float64:
+		buf := new(bytes.Buffer)
+		binary.Write(buf, binary.LittleEndian, val)
+		data = buf.Bytes()
+	case time.Time:
+		data, err = val.MarshalBinary()
+	case time.Duration:
+		data = make([]byte, 8)
+		binary.LittleEndian.PutUint64(data, uint64(val))
+	case []string:
+		// String slice format has uint16 number of strings and lengths
+		if err = checkStringSlice(val); err != nil {
+			return
+		}
+		buf := new(bytes.Buffer)
+		err = bslice.ByteSlice{}.WriteStringSlice(buf, val)
+		data = buf.Bytes()
+	case map[string]interface{}:
+		data, err = json.Marshal(val)
+	case RawValue:
+		t, data = binaryPrefix+val.Type, val.Data
+	case encoding.BinaryMarshaler:
+		t = binaryPrefix + t
+		data, err = val.MarshalBinary()
+	default:
+		if data, err = json.Marshal(val); err != nil {
+			err = fmt.Errorf("marshal error - %w: %s: %s", ErrUnsupportedType,
+				t, err)
+			return
+		}
+		t = jsonPrefix + t
+	}
+	return
+}
+
+// decodeValue return parameter value decoded from its type name and binary
+// representation
+func decodeValue(t string, data []byte) (v interface{}, err error) {
+	if c, ok := getValueCodec(t); ok {
+		return c.dec(data)
+	}
+
+	// read fixed size value from data
+	read := func(val interface{}) interface{} {
+		if binary.Size(val) != len(data) {
+			err = fmt.Errorf("unmarshal error - wrong %s value length: %d",
+				t, len(data))
+			return nil
+		}
+		binary.Read(bytes.NewReader(data), binary.LittleEndian, val)
+		return reflect.ValueOf(val).Elem().Interface()
+	}
+
+	switch t {
+	case "bool":
+		v = read(new(bool))
+	case "int8":
+		v = read(new(int8))
+	case "int16":
+		v = read(new(int16))
+	case "int32":
+		v = read(new(int32))
+	case "int64":
+		v = read(new(int64))
+	case "uint8":
+		v = read(new(uint8))
+	case "uint16":
+		v = read(new(uint16))
+	case "uint32":
+		v = read(new(uint32))
+	case "uint64":
+		v = read(new(uint64))
+	case "float32":
+		v = read(new(float32))
+	case "float64":
+		v = read(new(float64))
+
+	// The int and uint are encoded as 64 bit values, legacy format encode int
+	// as 32 bit value
+	case "int":
+		if len(data) == 4 {
+			v = int(read(new(int32)).(int32))
+			break
+		}
+		if val, ok := read(new(int64)).(int64); ok {
+			v = int(val)
+		}
+	case "uint":
+		if val, ok := read(new(uint64)).(uint64); ok {
+			v = uint(val)
+		}
+
+	case "string":
+		v = string(data)
+
+	case "[]uint8":
+		v = append([]byte{}, data...)
+
+	case "[]float64":
+		if len(data)%8 != 0 {
+			err = fmt.Errorf("unmarshal error - wrong %s value length: %d",
+				t, len(data))
+			break
+		}
+		val := make([]float64, len(data)/8)
+		binary.Read(bytes.NewReader(data), binary.LittleEndian, val)
+		v = val
+
+	case "[]string":
+		var val []string
+		val, err = bslice.ByteSlice{}.ReadStringSlice(bytes.NewBuffer(data))
+		if val == nil {
+			val = []string{}
+		}
+		v = val
+
+	case "time.Time":
+		var val time.Time
+		err = val.UnmarshalBinary(data)
+		v = val
+
+	case "time.Duration":
+		if val, ok := read(new(int64)).(int64); ok {
+			v = time.Duration(val)
+		}
+
+	case "map[string]interface {}":
+		var val map[string]interface{}
+		err = json.Unmarshal(data, &val)
+		v = val
+
+	default:
+		if strings.HasPrefix(t, binaryPrefix) {
+			t = strings.TrimPrefix(t, binaryPrefix)
+			v = RawValue{Type: t, Data: append([]byte{}, data...)}
+			break
+		}
+		if strings.HasPrefix(t, jsonPrefix) {
+			err = json.Unmarshal(data, &v)
+			break
+		}
+		err = fmt.Errorf("unmarshal error - %w: %s", ErrUnsupportedType, t)
+	}
+	if err != nil {
+		v = nil
+	}
+
+	return
+}
+
+// numericValue return parameter value as float64. Bool values are returned as
+// 1 or 0 and durations in seconds. The ok is false for non numeric values
+func numericValue(v interface{}) (f float64, ok bool) {
+	switch val := v.(type) {
+	case bool:
+		if val {
+			f = 1
+		}
+		return f, true
+	case time.Duration:
+		return val.Seconds(), true
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	return
+}
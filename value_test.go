@@ -0,0 +1,91 @@
+package teomon
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParameterStructured(t *testing.T) {
+
+	now := time.Now().Round(0)
+	ip := net.ParseIP("10.0.0.1")
+	ipData, _ := ip.MarshalText()
+
+	tests := []struct {
+		value    interface{}
+		expected interface{}
+	}{
+		{now, now},
+		{90 * time.Second, 90 * time.Second},
+		{[]string{"a", "b"}, []string{"a", "b"}},
+		{[]float64{1.5, -2}, []float64{1.5, -2}},
+		{
+			map[string]interface{}{"shard-1": 10.0, "ok": true},
+			map[string]interface{}{"shard-1": 10.0, "ok": true},
+		},
+		{
+			struct{ Name string }{"backup"},
+			map[string]interface{}{"Name": "backup"},
+		},
+		{&textMarshaler{ip}, RawValue{Type: "*teomon.textMarshaler", Data: ipData}},
+	}
+
+	for _, test := range tests {
+		par := Parameter{Name: "value", Value: test.value}
+		data, err := par.MarshalBinary()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		par = Parameter{}
+		if err = par.UnmarshalBinary(data); err != nil {
+			t.Error(err)
+			return
+		}
+		if tm, ok := par.Value.(time.Time); ok && tm.Equal(now) {
+			continue
+		}
+		if !reflect.DeepEqual(par.Value, test.expected) {
+			t.Errorf("wrong unmarshal %T value: %v != %v", test.value,
+				par.Value, test.expected)
+			return
+		}
+	}
+}
+
+// textMarshaler is encoding.BinaryMarshaler used in tests
+type textMarshaler struct{ ip net.IP }
+
+func (m *textMarshaler) MarshalBinary() ([]byte, error) { return m.ip.MarshalText() }
+
+func TestParameterStringSliceLimits(t *testing.T) {
+
+	for _, val := range [][]string{
+		make([]string, 1<<16),
+		{"a", strings.Repeat("x", 1<<16)},
+	} {
+		_, err := Parameter{Name: "list", Value: val}.MarshalBinary()
+		if !errors.Is(err, ErrUnsupportedType) {
+			t.Error("too big string slice marshalled", err)
+			return
+		}
+	}
+
+	// The biggest supported string
+	val := []string{strings.Repeat("x", 1<<16-1)}
+	data, err := Parameter{Name: "list", Value: val}.MarshalBinary()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var par Parameter
+	if err = par.UnmarshalBinary(data); err != nil || !reflect.DeepEqual(par.Value, val) {
+		t.Error("wrong string slice", err)
+		return
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2021-22 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet v5 monitoring wire format
+
+package teomon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Versioned wire format starts with header: two bytes magic and one byte
+// version. The magic can't be first bytes of legacy unversioned format where
+// first field is uint16 length of address or parameter name. Header followed
+// by fields in TLV format: one byte tag, uint32 length and value. Decoders
+// skip fields with unknown tags, so new fields may be added without breaking
+// older decoders.
+const (
+	wireMagic   uint16 = 0xFFFF
+	wireVersion byte   = 1
+)
+
+// Metric fields tags
+const (
+	metricAddress byte = iota + 1
+	metricAppName
+	metricAppShort
+	metricAppVersion
+	metricTeoVersion
+	metricAppStartTime
+	metricNew
+	metricParam
+	metricAggregates
+)
+
+// Parameter fields tags
+const (
+	paramName byte = iota + 1
+	paramType
+	paramValue
+)
+
+// Parameters batch fields tags
+const (
+	batchParam byte = iota + 1
+)
+
+// ErrWrongField returned when versioned data field is truncated
+var ErrWrongField = errors.New("wrong field length")
+
+// writeHeader write versioned format header
+func writeHeader(buf *bytes.Buffer) {
+	binary.Write(buf, binary.LittleEndian, wireMagic)
+	buf.WriteByte(wireVersion)
+}
+
+// readHeader read versioned format header. It returns false and does not
+// read anything if data is in legacy unversioned format
+func readHeader(buf *bytes.Buffer) (ok bool) {
+	data := buf.Bytes()
+	if len(data) < 3 || binary.LittleEndian.Uint16(data) != wireMagic {
+		return
+	}
+	buf.Next(3)
+	return true
+}
+
+// writeField write TLV field
+func writeField(buf *bytes.Buffer, tag byte, data []byte) {
+	buf.WriteByte(tag)
+	binary.Write(buf, binary.LittleEndian, uint32(len(data)))
+	buf.Write(data)
+}
+
+// readField read TLV field
+func readField(buf *bytes.Buffer) (tag byte, data []byte, err error) {
+	if tag, err = buf.ReadByte(); err != nil {
+		return
+	}
+	var l uint32
+	if err = binary.Read(buf, binary.LittleEndian, &l); err != nil {
+		return
+	}
+	if int(l) > buf.Len() {
+		err = fmt.Errorf("%w: tag %d, length %d", ErrWrongField, tag, l)
+		return
+	}
+	data = buf.Next(int(l))
+	return
+}
@@ -0,0 +1,82 @@
+package teomon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/kirill-scherba/bslice"
+)
+
+func TestLegacyFormat(t *testing.T) {
+
+	var b bslice.ByteSlice
+
+	// Legacy parameter
+	par := new(bytes.Buffer)
+	b.WriteSlice(par, []byte("num_users"))
+	b.WriteSlice(par, []byte("int"))
+	binary.Write(par, binary.LittleEndian, int32(-234))
+
+	// Legacy metric
+	buf := new(bytes.Buffer)
+	b.WriteSlice(buf, []byte("qUzILis"))
+	b.WriteSlice(buf, []byte("Test metric"))
+	b.WriteSlice(buf, []byte("test-metric"))
+	b.WriteSlice(buf, []byte("0.0.1"))
+	b.WriteSlice(buf, []byte("0.5.0"))
+	d, _ := time.Unix(1600000000, 0).MarshalBinary()
+	b.WriteSlice(buf, d)
+	binary.Write(buf, binary.LittleEndian, true)
+	binary.Write(buf, binary.LittleEndian, uint16(1))
+	b.WriteSlice(buf, par.Bytes())
+
+	m := NewMetric()
+	if err := m.UnmarshalBinary(buf.Bytes()); err != nil {
+		t.Error(err)
+		return
+	}
+	if m.Address != "qUzILis" || m.AppShort != "test-metric" ||
+		m.TeoVersion != "0.5.0" || !m.New ||
+		m.AppStartTime.Unix() != 1600000000 {
+		t.Error("wrong unmarshal legacy metric", m)
+		return
+	}
+	if val, _ := m.Params.Get("num_users"); val != -234 {
+		t.Error("wrong unmarshal legacy parameter", val)
+		return
+	}
+}
+
+func TestUnknownField(t *testing.T) {
+
+	m := NewMetric()
+	m.Address = "qUzILis"
+	m.Params.Add(ParamPeers, 5)
+	data, err := m.MarshalBinary()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Add field with unknown tag to the end of metric data
+	buf := bytes.NewBuffer(data)
+	writeField(buf, 200, []byte("new field"))
+
+	mout := NewMetric()
+	if err = mout.UnmarshalBinary(buf.Bytes()); err != nil {
+		t.Error(err)
+		return
+	}
+	if val, _ := mout.Params.Get(ParamPeers); mout.Address != "qUzILis" || val != 5 {
+		t.Error("wrong unmarshal metric with unknown field", mout.Address, val)
+		return
+	}
+
+	// Truncated field
+	if err = mout.UnmarshalBinary(data[:len(data)-1]); err == nil {
+		t.Error("truncated metric unmarshalled")
+		return
+	}
+}